@@ -0,0 +1,325 @@
+/*package io serializes gotetra density grids to and from the OVF format used
+throughout the OOMMF micromagnetics ecosystem, so that density grids can be
+inspected and post-processed by existing OVF tools.
+*/
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/phil-mansfield/gotetra/geom"
+)
+
+// Version identifies which OVF dialect a file is written in or read as.
+type Version int
+
+const (
+	Version1 Version = 1
+	Version2 Version = 2
+)
+
+// Encoding identifies the payload encoding used by an OVF file.
+type Encoding int
+
+const (
+	Text Encoding = iota
+	Binary4
+	Binary8
+)
+
+const (
+	binary4Control = 1234567.0
+	binary8Control = 123456789012345.0
+)
+
+// Meta describes the grid geometry and descriptive metadata carried by an
+// OVF header. It round-trips through Write/Read alongside the payload.
+type Meta struct {
+	Version   Version
+	Encoding  Encoding
+	Title     string
+	ValueUnit string
+	TotalTime float64
+
+	CellSize [3]float64
+	Origin   [3]float64
+	Width    [3]int
+}
+
+// Write writes rhos, a flat scalar grid described by cb and cellSize, to w
+// as an OVF file using the given version and encoding. rhos must have
+// cb.Width[0]*cb.Width[1]*cb.Width[2] elements.
+func Write(
+	w io.Writer, rhos []float64, cb *geom.CellBounds, cellSize float64,
+	origin [3]float64, meta Meta,
+) error {
+	meta.Width = cb.Width
+	meta.CellSize = [3]float64{cellSize, cellSize, cellSize}
+	meta.Origin = origin
+	if meta.Version == 0 {
+		meta.Version = Version2
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, meta); err != nil {
+		return err
+	}
+	if err := writeData(bw, rhos, meta); err != nil {
+		return err
+	}
+	if err := writeFooter(bw, meta); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeHeader(w *bufio.Writer, meta Meta) error {
+	nx, ny, nz := meta.Width[0], meta.Width[1], meta.Width[2]
+	xmax := meta.Origin[0] + meta.CellSize[0]*float64(nx)
+	ymax := meta.Origin[1] + meta.CellSize[1]*float64(ny)
+	zmax := meta.Origin[2] + meta.CellSize[2]*float64(nz)
+
+	lines := []string{
+		fmt.Sprintf("# OOMMF OVF %s", versionString(meta.Version)),
+		"# Segment count: 1",
+		"# Begin: Segment",
+		"# Begin: Header",
+		fmt.Sprintf("# Title: %s", meta.Title),
+		fmt.Sprintf("# valueunit: %s", meta.ValueUnit),
+		fmt.Sprintf("# totaltime: %g", meta.TotalTime),
+		"# meshtype: rectangular",
+		"# meshunit: 1.0",
+		fmt.Sprintf("# xbase: %g", meta.Origin[0]),
+		fmt.Sprintf("# ybase: %g", meta.Origin[1]),
+		fmt.Sprintf("# zbase: %g", meta.Origin[2]),
+		fmt.Sprintf("# xstepsize: %g", meta.CellSize[0]),
+		fmt.Sprintf("# ystepsize: %g", meta.CellSize[1]),
+		fmt.Sprintf("# zstepsize: %g", meta.CellSize[2]),
+		fmt.Sprintf("# xmin: %g", meta.Origin[0]),
+		fmt.Sprintf("# ymin: %g", meta.Origin[1]),
+		fmt.Sprintf("# zmin: %g", meta.Origin[2]),
+		fmt.Sprintf("# xmax: %g", xmax),
+		fmt.Sprintf("# ymax: %g", ymax),
+		fmt.Sprintf("# zmax: %g", zmax),
+		fmt.Sprintf("# xnodes: %d", nx),
+		fmt.Sprintf("# ynodes: %d", ny),
+		fmt.Sprintf("# znodes: %d", nz),
+		"# valuedim: 1",
+		"# End: Header",
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeData(w *bufio.Writer, rhos []float64, meta Meta) error {
+	switch meta.Encoding {
+	case Text:
+		if _, err := fmt.Fprintln(w, "# Begin: Data Text"); err != nil {
+			return err
+		}
+		for _, rho := range rhos {
+			if _, err := fmt.Fprintln(w, rho); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(w, "# End: Data Text")
+		return err
+	case Binary4:
+		if _, err := fmt.Fprintln(w, "# Begin: Data Binary 4"); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, float32(binary4Control)); err != nil {
+			return err
+		}
+		for _, rho := range rhos {
+			if err := binary.Write(w, binary.LittleEndian, float32(rho)); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintln(w, "\n# End: Data Binary 4")
+		return err
+	case Binary8:
+		if _, err := fmt.Fprintln(w, "# Begin: Data Binary 8"); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, float64(binary8Control)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, rhos); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w, "\n# End: Data Binary 8")
+		return err
+	default:
+		return fmt.Errorf("unrecognized OVF encoding %d", meta.Encoding)
+	}
+}
+
+func writeFooter(w *bufio.Writer, meta Meta) error {
+	_, err := fmt.Fprintln(w, "# End: Segment")
+	return err
+}
+
+func versionString(v Version) string {
+	if v == Version1 {
+		return "1.0"
+	}
+	return "2.0"
+}
+
+// Read reads an OVF file from r, autodetecting OVF1 vs OVF2 and the text,
+// binary-4, or binary-8 payload encoding, and returns the flat grid
+// together with the Meta describing it.
+func Read(r io.Reader) ([]float64, *Meta, error) {
+	br := bufio.NewReader(r)
+	meta := &Meta{}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, nil, fmt.Errorf("OVF file ended before data section")
+		}
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "# OOMMF OVF"):
+			if strings.Contains(trimmed, "1.0") {
+				meta.Version = Version1
+			} else {
+				meta.Version = Version2
+			}
+		case strings.HasPrefix(trimmed, "# Title:"):
+			meta.Title = strings.TrimSpace(strings.TrimPrefix(trimmed, "# Title:"))
+		case strings.HasPrefix(trimmed, "# valueunit:"):
+			meta.ValueUnit = strings.TrimSpace(strings.TrimPrefix(trimmed, "# valueunit:"))
+		case strings.HasPrefix(trimmed, "# totaltime:"):
+			meta.TotalTime = parseFloat(strings.TrimPrefix(trimmed, "# totaltime:"))
+		case strings.HasPrefix(trimmed, "# xbase:"):
+			meta.Origin[0] = parseFloat(strings.TrimPrefix(trimmed, "# xbase:"))
+		case strings.HasPrefix(trimmed, "# ybase:"):
+			meta.Origin[1] = parseFloat(strings.TrimPrefix(trimmed, "# ybase:"))
+		case strings.HasPrefix(trimmed, "# zbase:"):
+			meta.Origin[2] = parseFloat(strings.TrimPrefix(trimmed, "# zbase:"))
+		case strings.HasPrefix(trimmed, "# xstepsize:"):
+			meta.CellSize[0] = parseFloat(strings.TrimPrefix(trimmed, "# xstepsize:"))
+		case strings.HasPrefix(trimmed, "# ystepsize:"):
+			meta.CellSize[1] = parseFloat(strings.TrimPrefix(trimmed, "# ystepsize:"))
+		case strings.HasPrefix(trimmed, "# zstepsize:"):
+			meta.CellSize[2] = parseFloat(strings.TrimPrefix(trimmed, "# zstepsize:"))
+		case strings.HasPrefix(trimmed, "# xnodes:"):
+			meta.Width[0] = int(parseFloat(strings.TrimPrefix(trimmed, "# xnodes:")))
+		case strings.HasPrefix(trimmed, "# ynodes:"):
+			meta.Width[1] = int(parseFloat(strings.TrimPrefix(trimmed, "# ynodes:")))
+		case strings.HasPrefix(trimmed, "# znodes:"):
+			meta.Width[2] = int(parseFloat(strings.TrimPrefix(trimmed, "# znodes:")))
+		case strings.HasPrefix(trimmed, "# Begin: Data"):
+			n := meta.Width[0] * meta.Width[1] * meta.Width[2]
+			rhos, enc, err := readData(br, trimmed, n)
+			if err != nil {
+				return nil, nil, err
+			}
+			meta.Encoding = enc
+			return rhos, meta, nil
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return nil, nil, fmt.Errorf("OVF file ended before data section")
+}
+
+func readData(br *bufio.Reader, beginLine string, n int) ([]float64, Encoding, error) {
+	switch {
+	case strings.Contains(beginLine, "Text"):
+		rhos := make([]float64, 0, n)
+		for len(rhos) < n {
+			line, err := br.ReadString('\n')
+			trimmed := strings.TrimSpace(line)
+			if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				rhos = append(rhos, parseFloat(trimmed))
+			}
+			if err != nil {
+				return nil, Text, err
+			}
+		}
+		return rhos, Text, nil
+	case strings.Contains(beginLine, "Binary 4"):
+		var control float32
+		if err := binary.Read(br, binary.LittleEndian, &control); err != nil {
+			return nil, Binary4, err
+		}
+		if control != binary4Control {
+			return nil, Binary4, fmt.Errorf(
+				"bad OVF binary-4 control number %g", control)
+		}
+		vals := make([]float32, n)
+		if err := binary.Read(br, binary.LittleEndian, vals); err != nil {
+			return nil, Binary4, err
+		}
+		rhos := make([]float64, n)
+		for i, v := range vals {
+			rhos[i] = float64(v)
+		}
+		return rhos, Binary4, nil
+	case strings.Contains(beginLine, "Binary 8"):
+		var control float64
+		if err := binary.Read(br, binary.LittleEndian, &control); err != nil {
+			return nil, Binary8, err
+		}
+		if control != binary8Control {
+			return nil, Binary8, fmt.Errorf(
+				"bad OVF binary-8 control number %g", control)
+		}
+		rhos := make([]float64, n)
+		if err := binary.Read(br, binary.LittleEndian, rhos); err != nil {
+			return nil, Binary8, err
+		}
+		return rhos, Binary8, nil
+	default:
+		return nil, Text, fmt.Errorf("unrecognized OVF data section %q", beginLine)
+	}
+}
+
+func parseFloat(s string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// WriteFile is a convenience wrapper around Write that creates fileName.
+func WriteFile(
+	fileName string, rhos []float64, cb *geom.CellBounds, cellSize float64,
+	origin [3]float64, meta Meta,
+) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return Write(f, rhos, cb, cellSize, origin, meta)
+}
+
+// ReadFile is a convenience wrapper around Read that opens fileName.
+func ReadFile(fileName string) ([]float64, *Meta, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
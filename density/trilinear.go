@@ -0,0 +1,224 @@
+package density
+
+import (
+	"github.com/phil-mansfield/gotetra/geom"
+)
+
+// cubeWalkCap bounds the number of cells a single ray is allowed to cross
+// before giving up. It exists only to guarantee termination for rays that
+// are nearly parallel to a cell face, where floating point error could
+// otherwise stall advancement.
+const cubeWalkCap = 1 << 16
+
+// cubeIntegral is an Interpolator which deposits tetrahedra by analytically
+// integrating a trilinear density field along each of the six rays used by
+// the Monte Carlo scheme's tetrahedron decomposition, rather than by point
+// sampling those same tetrahedra.
+type cubeIntegral struct {
+	segWidth int64
+	skip     int64
+	eps      float64
+
+	idxBuf geom.TetraIdxs
+	tet    geom.Tetra
+}
+
+// TrilinearCubeIntegral returns an Interpolator which deposits each
+// tetrahedron's mass by analytically integrating a trilinear density field
+// along the tetrahedron's traversal of every grid cell it crosses, rather
+// than by Monte Carlo point sampling. Because the integral is evaluated
+// exactly rather than sampled, it has no shot-noise floor, at a cost per
+// tetrahedron comparable to the Monte Carlo scheme.
+func TrilinearCubeIntegral(segWidth, skip int64) Interpolator {
+	return &cubeIntegral{
+		segWidth, skip, 1e-6, geom.TetraIdxs{}, geom.Tetra{},
+	}
+}
+
+func (intr *cubeIntegral) Interpolate(
+	rhos []float64, cb *geom.CellBounds, ptRho float64, xs []geom.Vec,
+	low, high int,
+) {
+	segWidth := intr.segWidth
+	gridWidth := segWidth + 1
+	idxWidth := intr.segWidth / intr.skip
+
+	// Every tetrahedron is walked exactly once, so unlike the Monte Carlo
+	// scheme there's no need to divide ptRho by a sample count.
+	rayRho := ptRho / 6.0 * float64(intr.skip*intr.skip*intr.skip)
+
+	for idx := int64(low); idx < int64(high); idx++ {
+		x, y, z := coords(idx, idxWidth)
+		gridIdx := index(x, y, z, gridWidth)
+
+		for dir := 0; dir < 6; dir++ {
+			intr.idxBuf.Init(gridIdx, gridWidth, intr.skip, dir)
+			intr.tet.Init(
+				&xs[intr.idxBuf[0]],
+				&xs[intr.idxBuf[1]],
+				&xs[intr.idxBuf[2]],
+				&xs[intr.idxBuf[3]],
+				1e6,
+			)
+
+			intr.depositTetra(rhos, cb, rayRho)
+		}
+	}
+}
+
+// depositTetra deposits the mass of the tetrahedron currently held in
+// intr.tet by analytically integrating along the ray that runs from its
+// centroid to the midpoint of the opposite edge of its long diagonal,
+// walking cell-by-cell across every cell the ray crosses.
+func (intr *cubeIntegral) depositTetra(
+	rhos []float64, cb *geom.CellBounds, rho float64,
+) {
+	c0, dir := intr.tet.CentroidRay()
+
+	cellX, cellY, cellZ := int(c0[0]), int(c0[1]), int(c0[2])
+	u0 := [3]float64{
+		float64(c0[0]) - float64(cellX),
+		float64(c0[1]) - float64(cellY),
+		float64(c0[2]) - float64(cellZ),
+	}
+	u := [3]float64{float64(dir[0]), float64(dir[1]), float64(dir[2])}
+
+	// remaining is how much of the ray, in units of dir, is left to walk;
+	// the walk ends at the ray's far end (alpha summing to 1).
+	remaining := 1.0
+	for step := 0; step < cubeWalkCap; step++ {
+		alpha, axis, sign := cubeExitAlpha(u0, u, intr.eps)
+		if alpha < 0 {
+			// The ray is degenerate along every axis: there's nowhere left
+			// to go, so stop rather than loop forever.
+			return
+		}
+		last := alpha >= remaining
+		if last {
+			alpha = remaining
+		}
+
+		// The CIC weights are trilinear in u, so along a straight segment
+		// they are cubic in alpha, and Simpson's rule over the segment's two
+		// ends and midpoint integrates them exactly.
+		mid := [3]float64{
+			u0[0] + alpha*u[0]/2, u0[1] + alpha*u[1]/2, u0[2] + alpha*u[2]/2,
+		}
+		end := [3]float64{
+			u0[0] + alpha*u[0], u0[1] + alpha*u[1], u0[2] + alpha*u[2],
+		}
+		depositCIC(rhos, cb, cellX, cellY, cellZ, u0, rho*alpha/6)
+		depositCIC(rhos, cb, cellX, cellY, cellZ, mid, rho*alpha*4/6)
+		depositCIC(rhos, cb, cellX, cellY, cellZ, end, rho*alpha/6)
+		if last {
+			return
+		}
+		remaining -= alpha
+
+		for i := range u0 {
+			u0[i] += alpha * u[i]
+		}
+
+		switch axis {
+		case 0:
+			cellX += sign
+			u0[0] = 0
+			if sign < 0 {
+				u0[0] = 1
+			}
+		case 1:
+			cellY += sign
+			u0[1] = 0
+			if sign < 0 {
+				u0[1] = 1
+			}
+		case 2:
+			cellZ += sign
+			u0[2] = 0
+			if sign < 0 {
+				u0[2] = 1
+			}
+		default:
+			return
+		}
+	}
+}
+
+// cubeExitAlpha returns the smallest positive alpha such that
+// u0[i] + alpha*u[i] hits 0 or 1 on some axis i, along with that axis and
+// the direction (+1 or -1) the walk should step in on that axis. Axes with
+// |u[i]| < eps are skipped since the ray never reaches a face along them.
+func cubeExitAlpha(u0, u [3]float64, eps float64) (alpha float64, axis, sign int) {
+	alpha = -1
+	axis = -1
+	for i := 0; i < 3; i++ {
+		if u[i] > -eps && u[i] < eps {
+			continue
+		}
+
+		target, s := 1.0, 1
+		if u[i] < 0 {
+			target, s = 0.0, -1
+		}
+
+		a := (target - u0[i]) / u[i]
+		if a < 0 {
+			a = 0
+		}
+		if alpha < 0 || a < alpha {
+			alpha, axis, sign = a, i, s
+		}
+	}
+	return alpha, axis, sign
+}
+
+// depositCIC adds mass to the eight grid corners surrounding the unit cube
+// cell (cellX, cellY, cellZ), splitting it according to the trilinear (CIC)
+// weight of each corner at the fractional position u within that cell.
+// Because trilinearCorner's eight weights always sum to exactly 1, the
+// full mass is conserved: this is what actually distributes a
+// tetrahedron's deposit across the cube's eight vertex densities instead
+// of dumping it into whichever single cell the ray happens to be crossing.
+//
+// Corners are indexed into rhos unwrapped, exactly as ngp.Interpolate
+// indexes its own points: rhos is a local buffer covering only cb's
+// bounds, not the full periodic grid, so folding cellX+dx/etc. against
+// cb.Width here would fold a corner that's one cell past this buffer's
+// edge back onto this same buffer's opposite face -- depositing mass on
+// the wrong vertex entirely instead of the neighboring cell's. AddBuffer
+// is what actually wraps a buffer's contents onto the periodic grid, via
+// cb.Origin against the grid's own cell count, once every Interpolate
+// call into this buffer is done.
+func depositCIC(
+	rhos []float64, cb *geom.CellBounds, cellX, cellY, cellZ int,
+	u [3]float64, mass float64,
+) {
+	for corner := 0; corner < 8; corner++ {
+		dx, dy, dz := corner&1, (corner>>1)&1, (corner>>2)&1
+		w := trilinearCorner(u, dx, dy, dz)
+		if w == 0 {
+			continue
+		}
+		gx, gy, gz := cellX+dx, cellY+dy, cellZ+dz
+		rhos[gx+gy*cb.Width[0]+gz*cb.Width[0]*cb.Width[1]] += mass * w
+	}
+}
+
+// trilinearCorner evaluates the trilinear (CIC) weight of the unit-cube
+// corner at (dx, dy, dz) -- each 0 or 1 -- for a point at fractional
+// position u within the cube. Summing trilinearCorner over all eight
+// corners of a cube always yields exactly 1, so distributing a mass by
+// these weights conserves it exactly across the cell's eight vertex
+// densities.
+func trilinearCorner(u [3]float64, dx, dy, dz int) float64 {
+	return axisWeight(u[0], dx) * axisWeight(u[1], dy) * axisWeight(u[2], dz)
+}
+
+// axisWeight returns u (if corner == 1) or 1-u (if corner == 0), the
+// standard linear interpolation weight along one axis of a CIC deposit.
+func axisWeight(u float64, corner int) float64 {
+	if corner == 0 {
+		return 1 - u
+	}
+	return u
+}
@@ -0,0 +1,328 @@
+package analyze
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// percentileLo, percentileHi bound the central percentile interval Stat
+// reports alongside its Gaussian-equivalent bootstrap standard error: a
+// 1-sigma-equivalent interval, but one that can come out asymmetric (or
+// skip the symmetric-error assumption entirely) when the bootstrap
+// resample distribution itself is skewed, which BootstrapErr alone can't
+// represent.
+const (
+	percentileLo = 0.16
+	percentileHi = 0.84
+)
+
+// Stat is a scalar shell quantity's ensemble mean, its jackknife and
+// bootstrap standard errors, and the 16th/84th percentile interval of its
+// bootstrap resample distribution (a 1-sigma-equivalent interval that, unlike
+// BootstrapErr, captures any skew in how the mean resamples).
+type Stat struct {
+	Mean         float64
+	JackknifeErr float64
+	BootstrapErr float64
+	BootstrapLo  float64
+	BootstrapHi  float64
+}
+
+// ShellEnsemble collects the per-realization Shells fit to a halo's
+// reference-orientation RingBuffers (one PennaPlaneFit per rotated
+// realization -- see los/main's ProcessHalo) and derives bootstrap and
+// jackknife uncertainties on the scalar shell quantities from their
+// spread, instead of trusting any single fit's value.
+type ShellEnsemble struct {
+	shells  []Shell
+	weights []float64 // nil means every realization is weighted equally
+	samples int
+}
+
+// NewShellEnsemble wraps shells, the per-reference-realization Penna
+// fits produced for one halo. samples is forwarded to every Shell method
+// call the ensemble makes (Volume(samples), SurfaceArea(samples), etc.).
+func NewShellEnsemble(shells []Shell, samples int) *ShellEnsemble {
+	return &ShellEnsemble{shells: shells, samples: samples}
+}
+
+// NewWeightedShellEnsemble is like NewShellEnsemble, but weights each
+// realization's contribution to the mean and its uncertainty by
+// weights[i]. This is for realizations drawn from a non-uniform
+// orientation scheme (e.g. los/main's importanceAxes) where every draw
+// still needs to count as if it were importance-corrected back to an
+// isotropic average. len(weights) must equal len(shells).
+func NewWeightedShellEnsemble(
+	shells []Shell, weights []float64, samples int,
+) *ShellEnsemble {
+	return &ShellEnsemble{shells: shells, weights: weights, samples: samples}
+}
+
+// Len returns the number of realizations in the ensemble.
+func (e *ShellEnsemble) Len() int { return len(e.shells) }
+
+// Volume returns the ensemble's mean Volume and its uncertainty.
+func (e *ShellEnsemble) Volume() Stat {
+	return e.stat(func(s Shell) float64 { return s.Volume(e.samples) })
+}
+
+// SurfaceArea returns the ensemble's mean SurfaceArea and its
+// uncertainty.
+func (e *ShellEnsemble) SurfaceArea() Stat {
+	return e.stat(func(s Shell) float64 { return s.SurfaceArea(e.samples) })
+}
+
+// Rsp returns the ensemble's mean splashback radius and its uncertainty.
+func (e *ShellEnsemble) Rsp() Stat {
+	return e.stat(func(s Shell) float64 { return s.Rsp(e.samples) })
+}
+
+// MeanRadius returns the ensemble's mean MeanRadius and its uncertainty.
+func (e *ShellEnsemble) MeanRadius() Stat {
+	return e.stat(func(s Shell) float64 { return s.MeanRadius(e.samples) })
+}
+
+// MedianRadius returns the ensemble's mean MedianRadius and its
+// uncertainty.
+func (e *ShellEnsemble) MedianRadius() Stat {
+	return e.stat(func(s Shell) float64 { return s.MedianRadius(e.samples) })
+}
+
+// Moments returns Stats for each of the three principal moments.
+func (e *ShellEnsemble) Moments() (ix, iy, iz Stat) {
+	ix = e.stat(func(s Shell) float64 {
+		x, _, _ := s.Moments(e.samples)
+		return x
+	})
+	iy = e.stat(func(s Shell) float64 {
+		_, y, _ := s.Moments(e.samples)
+		return y
+	})
+	iz = e.stat(func(s Shell) float64 {
+		_, _, z := s.Moments(e.samples)
+		return z
+	})
+	return ix, iy, iz
+}
+
+// RadialRange returns separate Stats for the ensemble's minimum and
+// maximum radius.
+func (e *ShellEnsemble) RadialRange() (rMin, rMax Stat) {
+	rMin = e.stat(func(s Shell) float64 {
+		lo, _ := s.RadialRange(e.samples)
+		return lo
+	})
+	rMax = e.stat(func(s Shell) float64 {
+		_, hi := s.RadialRange(e.samples)
+		return hi
+	})
+	return rMin, rMax
+}
+
+// stat evaluates f on every realization in the ensemble and returns the
+// resulting mean, jackknife error, and bootstrap error.
+func (e *ShellEnsemble) stat(f func(Shell) float64) Stat {
+	xs := make([]float64, len(e.shells))
+	for i, s := range e.shells {
+		xs[i] = f(s)
+	}
+
+	if e.weights == nil {
+		mean := meanOf(xs)
+		err, lo, hi := bootstrapStats(xs)
+		return Stat{
+			Mean:         mean,
+			JackknifeErr: jackknifeErr(xs, mean),
+			BootstrapErr: err,
+			BootstrapLo:  lo,
+			BootstrapHi:  hi,
+		}
+	}
+
+	mean := weightedMeanOf(xs, e.weights)
+	err, lo, hi := weightedBootstrapStats(xs, e.weights)
+	return Stat{
+		Mean:         mean,
+		JackknifeErr: weightedJackknifeErr(xs, e.weights, mean),
+		BootstrapErr: err,
+		BootstrapLo:  lo,
+		BootstrapHi:  hi,
+	}
+}
+
+func meanOf(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func varOf(xs []float64, mean float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	ss := 0.0
+	for _, x := range xs {
+		d := x - mean
+		ss += d * d
+	}
+	return ss / float64(len(xs)-1)
+}
+
+// jackknifeErr computes the leave-one-out jackknife standard error of
+// the mean of xs.
+func jackknifeErr(xs []float64, mean float64) float64 {
+	n := len(xs)
+	if n < 2 {
+		return 0
+	}
+
+	total := mean * float64(n)
+	ss := 0.0
+	for _, x := range xs {
+		loo := (total - x) / float64(n-1)
+		d := loo - mean
+		ss += d * d
+	}
+	return math.Sqrt(float64(n-1) / float64(n) * ss)
+}
+
+// weightedMeanOf returns the weighted mean of xs under weights ws.
+func weightedMeanOf(xs, ws []float64) float64 {
+	sumW, sum := 0.0, 0.0
+	for i := range xs {
+		sum += xs[i] * ws[i]
+		sumW += ws[i]
+	}
+	if sumW == 0 {
+		return 0
+	}
+	return sum / sumW
+}
+
+// weightedJackknifeErr computes the leave-one-out jackknife standard
+// error of the weighted mean of xs under weights ws.
+func weightedJackknifeErr(xs, ws []float64, mean float64) float64 {
+	n := len(xs)
+	if n < 2 {
+		return 0
+	}
+
+	sumW := 0.0
+	for _, w := range ws {
+		sumW += w
+	}
+
+	ss := 0.0
+	for i := range xs {
+		looSumW := sumW - ws[i]
+		if looSumW <= 0 {
+			continue
+		}
+		loo := (mean*sumW - xs[i]*ws[i]) / looSumW
+		d := loo - mean
+		ss += d * d
+	}
+	return math.Sqrt(float64(n-1) / float64(n) * ss)
+}
+
+// weightedBootstrapStats resamples indices with replacement according to
+// ws and returns the resulting standard error of the weighted mean of xs,
+// plus the percentileLo/percentileHi interval of the resample
+// distribution itself.
+func weightedBootstrapStats(xs, ws []float64) (err, lo, hi float64) {
+	n := len(xs)
+	if n < 2 {
+		return 0, 0, 0
+	}
+
+	cum := make([]float64, n)
+	sum := 0.0
+	for i, w := range ws {
+		sum += w
+		cum[i] = sum
+	}
+	if sum == 0 {
+		return 0, 0, 0
+	}
+
+	means := make([]float64, bootstrapResamples)
+	for b := range means {
+		s := 0.0
+		for i := 0; i < n; i++ {
+			s += xs[weightedSearch(cum, rand.Float64()*sum)]
+		}
+		means[b] = s / float64(n)
+	}
+
+	mean := meanOf(means)
+	lo, hi = percentileInterval(means)
+	return math.Sqrt(varOf(means, mean)), lo, hi
+}
+
+// weightedSearch returns the first index i with target <= cum[i].
+func weightedSearch(cum []float64, target float64) int {
+	for i, c := range cum {
+		if target <= c {
+			return i
+		}
+	}
+	return len(cum) - 1
+}
+
+// bootstrapResamples is how many resampled means bootstrapStats (and
+// weightedBootstrapStats) draw to
+// estimate the standard error of the mean.
+const bootstrapResamples = 1000
+
+// bootstrapStats resamples xs with replacement bootstrapResamples times
+// and returns the resulting standard error of the mean, plus the
+// percentileLo/percentileHi interval of the resample distribution itself.
+func bootstrapStats(xs []float64) (err, lo, hi float64) {
+	n := len(xs)
+	if n < 2 {
+		return 0, 0, 0
+	}
+
+	means := make([]float64, bootstrapResamples)
+	for b := range means {
+		sum := 0.0
+		for i := 0; i < n; i++ {
+			sum += xs[rand.Intn(n)]
+		}
+		means[b] = sum / float64(n)
+	}
+
+	mean := meanOf(means)
+	lo, hi = percentileInterval(means)
+	return math.Sqrt(varOf(means, mean)), lo, hi
+}
+
+// percentileInterval returns the [percentileLo, percentileHi] quantiles of
+// xs, sorting a copy so the caller's slice order is left untouched.
+func percentileInterval(xs []float64) (lo, hi float64) {
+	n := len(xs)
+	if n == 0 {
+		return 0, 0
+	}
+	sorted := make([]float64, n)
+	copy(sorted, xs)
+	sort.Float64s(sorted)
+
+	idx := func(q float64) float64 {
+		pos := q * float64(n-1)
+		i0 := int(pos)
+		i1 := i0 + 1
+		if i1 > n-1 {
+			i1 = n - 1
+		}
+		frac := pos - float64(i0)
+		return sorted[i0] + (sorted[i1]-sorted[i0])*frac
+	}
+	return idx(percentileLo), idx(percentileHi)
+}
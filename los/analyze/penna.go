@@ -1,9 +1,8 @@
 package analyze
 
 import (
-	"math"
-	
 	"github.com/phil-mansfield/gotetra/math/mat"
+	"github.com/phil-mansfield/gotetra/math/ops"
 	"github.com/gonum/matrix/mat64"
 )
 
@@ -42,9 +41,9 @@ func PennaCoeffs(xs, ys, zs []float64, I, J, K int) []float64 {
 
 	// Precompute trig functions.
 	for i := range rs {
-		rs[i] = math.Sqrt(xs[i]*xs[i] + ys[i]*ys[i] + zs[i]*zs[i])
+		rs[i] = ops.Sqrt(xs[i]*xs[i] + ys[i]*ys[i] + zs[i]*zs[i])
 		cosths[i] = zs[i] / rs[i]
-		sinths[i] = math.Sqrt(1 - cosths[i]*cosths[i])
+		sinths[i] = ops.Sqrt(1 - cosths[i]*cosths[i])
 		cosphis[i] = xs[i] / rs[i] / sinths[i]
 		sinphis[i] = ys[i] / rs[i] / sinths[i]
 	}
@@ -56,15 +55,15 @@ func PennaCoeffs(xs, ys, zs []float64, I, J, K int) []float64 {
 	for n := 0; n < N; n++ {
 		m := 0
 		for k := 0; k < K; k++ {
-			costh := math.Pow(cosths[n], float64(k))
+			costh := ops.Pow(cosths[n], float64(k))
 			for j := 0; j < J; j++ {
-				sinphi := math.Pow(sinphis[n], float64(j))
+				sinphi := ops.Pow(sinphis[n], float64(j))
 				cosphi := 1.0
 				for i := 0; i < I; i++ {
 					// sin(th) can't be done via multiplication because the
 					// floating point errors are too large.
 					MVals[m*M.Width + n] =
-						math.Pow(sinths[n], float64(i+j)) *
+						ops.Pow(sinths[n], float64(i+j)) *
 						cosphi * costh * sinphi
 					m++
 					cosphi *= cosphis[n]
@@ -81,16 +80,16 @@ func PennaCoeffs(xs, ys, zs []float64, I, J, K int) []float64 {
 func PennaFunc(cs []float64, I, J, K int) func(phi, th float64) float64 {
 	return func(phi, th float64) float64 {
 		idx, sum := 0, 0.0
-		sinPhi, cosPhi := math.Sincos(phi)
-		sinTh, cosTh := math.Sincos(th)
+		sinPhi, cosPhi := ops.Sincos(phi)
+		sinTh, cosTh := ops.Sincos(th)
 
 		for k := 0; k < K; k++ {
-			cosK := math.Pow(cosTh, float64(k))
+			cosK := ops.Pow(cosTh, float64(k))
 			for j := 0; j < J; j++ {
-				sinJ := math.Pow(sinPhi, float64(j))
+				sinJ := ops.Pow(sinPhi, float64(j))
 				for i := 0; i < I; i++ {
-					cosI := math.Pow(cosPhi, float64(i))
-					sinIJ := math.Pow(sinTh, float64(i+j))
+					cosI := ops.Pow(cosPhi, float64(i))
+					sinIJ := ops.Pow(sinTh, float64(i+j))
 					sum += cs[idx] * sinIJ * cosK * sinJ * cosI
 					idx++
 				}
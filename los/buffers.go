@@ -0,0 +1,89 @@
+package los
+
+import (
+	rgeom "github.com/phil-mansfield/gotetra/render/geom"
+	"github.com/phil-mansfield/gotetra/render/io"
+)
+
+// SheetCache is the subset of a decoded-sheet cache's API Buffers needs to
+// share sheet reads across workers. los/main's SheetCache (a byte-budgeted
+// LRU cache) satisfies this interface structurally; los doesn't import
+// los/main (which already imports los) to avoid a cycle.
+type SheetCache interface {
+	Get(file string) (interface{}, bool)
+	Put(file string, payload interface{}, bytes int64)
+}
+
+// BufferOption configures optional behavior passed to NewBuffers.
+type BufferOption func(*Buffers)
+
+// Cache shares a SheetCache across every Buffers built with it, so that
+// workers in a pool which happen to read the same sheet file only pay for
+// the first decode (see Buffers.Load).
+func Cache(c SheetCache) BufferOption {
+	return func(b *Buffers) { b.cache = c }
+}
+
+// SubsampleLength records the particle subsampling stride on the Buffers.
+// Buffers.Load itself does not subsample: it always decodes and caches
+// every particle of the segment.
+func SubsampleLength(n int) BufferOption {
+	return func(b *Buffers) { b.subsampleLength = n }
+}
+
+// Buffers holds one worker's reusable sheet-decoding scratch space. A
+// Buffers built with the Cache option looks up a sheet file's decoded
+// positions in the shared cache before reading it from disk, and stores
+// what it reads back into the cache for the next Buffers (in this worker
+// or another) that needs the same file.
+type Buffers struct {
+	file            string
+	hd              *io.SheetHeader
+	subsampleLength int
+	cache           SheetCache
+
+	scratch []rgeom.Vec
+}
+
+// NewBuffers returns a Buffers for decoding sheets shaped like the one at
+// file/hd. By default it reads every particle (SubsampleLength(1)) and
+// doesn't share reads with any other Buffers; pass Cache(c) to share reads
+// through c instead.
+func NewBuffers(file string, hd *io.SheetHeader, opts ...BufferOption) *Buffers {
+	b := &Buffers{file: file, hd: hd, subsampleLength: 1}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Load returns file's decoded particle positions, reading the whole
+// segment from disk via render/io on a cache miss and, if b has a cache,
+// storing the result for the next caller that asks for the same file.
+func (b *Buffers) Load(file string, hd *io.SheetHeader) ([]rgeom.Vec, error) {
+	if b.cache != nil {
+		if cached, ok := b.cache.Get(file); ok {
+			return cached.([]rgeom.Vec), nil
+		}
+	}
+
+	n := hd.GridWidth * hd.GridWidth * hd.SegmentWidth
+	if int64(cap(b.scratch)) < n {
+		b.scratch = make([]rgeom.Vec, n)
+	}
+	xs := b.scratch[:n]
+	if err := io.ReadSheetPositionsChunked(file, 0, int(hd.SegmentWidth), xs); err != nil {
+		return nil, err
+	}
+
+	if b.cache != nil {
+		b.cache.Put(file, xs, n*vecBytes)
+		// The cache now owns xs; Buffers needs a fresh scratch slice for
+		// its next Load so it doesn't mutate what the cache just stored.
+		b.scratch = nil
+	}
+	return xs, nil
+}
+
+// vecBytes is the in-memory size, in bytes, of one render/geom.Vec.
+const vecBytes = 12
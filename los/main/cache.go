@@ -0,0 +1,97 @@
+package main
+
+import "sync"
+
+// SheetCache is a byte-budgeted, LRU-evicted cache of decoded sheet
+// payloads, shared across the worker pool's los.Buffers so that two
+// halos processed by different workers that happen to intersect the
+// same sheet file only pay for the first decode.
+type SheetCache struct {
+	mu sync.Mutex
+
+	capBytes int64
+	curBytes int64
+
+	order   []string // front = least recently used
+	entries map[string]cacheEntry
+
+	hits, misses int64
+}
+
+type cacheEntry struct {
+	payload interface{}
+	bytes   int64
+}
+
+// NewSheetCache returns a SheetCache that evicts least-recently-used
+// entries once their combined size would exceed capBytes.
+func NewSheetCache(capBytes int64) *SheetCache {
+	return &SheetCache{
+		capBytes: capBytes,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// Get returns the cached payload for file, if present, marking it most
+// recently used.
+func (c *SheetCache) Get(file string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[file]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.touch(file)
+	return e.payload, true
+}
+
+// Put stores payload under file, sized at bytes, evicting
+// least-recently-used entries until the cache is back under budget.
+func (c *SheetCache) Put(file string, payload interface{}, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[file]; ok {
+		c.curBytes -= old.bytes
+	} else {
+		c.order = append(c.order, file)
+	}
+	c.entries[file] = cacheEntry{payload: payload, bytes: bytes}
+	c.curBytes += bytes
+
+	for c.curBytes > c.capBytes && len(c.order) > 0 {
+		victim := c.order[0]
+		c.order = c.order[1:]
+		if e, ok := c.entries[victim]; ok {
+			c.curBytes -= e.bytes
+			delete(c.entries, victim)
+		}
+	}
+}
+
+// touch moves file to the most-recently-used end of c.order. Caller must
+// hold c.mu.
+func (c *SheetCache) touch(file string) {
+	for i, f := range c.order {
+		if f == file {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	c.order = append(c.order, file)
+}
+
+// HitRate returns the fraction of Get calls so far that found a cached
+// payload.
+func (c *SheetCache) HitRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := c.hits + c.misses
+	if total == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(total)
+}
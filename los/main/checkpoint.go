@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+)
+
+const checkpointSaveFile = "checkpoint.dat"
+
+// checkpointKey identifies one (RID, rotation seed) pair that ProcessHalo
+// has already run to completion, so a long batch run can resume after an
+// interruption without redoing finished halos.
+type checkpointKey struct {
+	RID  int32
+	Seed int64
+}
+
+// loadCheckpoint reads the set of already-completed (RID, seed) pairs
+// from saveDir, using the same flat binary-record cache file layout
+// loadHeaders already uses for hdSaveFile.
+func loadCheckpoint(saveDir string) (map[checkpointKey]bool, error) {
+	done := make(map[checkpointKey]bool)
+	saveFile := path.Join(saveDir, checkpointSaveFile)
+
+	f, err := os.Open(saveFile)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	for {
+		var key checkpointKey
+		if err := binary.Read(f, binary.LittleEndian, &key); err != nil {
+			break
+		}
+		done[key] = true
+	}
+	return done, nil
+}
+
+// appendCheckpoint records that key has completed by appending it to
+// saveDir's checkpoint file.
+func appendCheckpoint(saveDir string, key checkpointKey) error {
+	saveFile := path.Join(saveDir, checkpointSaveFile)
+	f, err := os.OpenFile(
+		saveFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return binary.Write(f, binary.LittleEndian, key)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+
+	"github.com/phil-mansfield/gotetra/los"
+	"github.com/phil-mansfield/gotetra/los/geom"
+	rgeom "github.com/phil-mansfield/gotetra/render/geom"
+	"github.com/phil-mansfield/gotetra/render/io"
+)
+
+// buildCoarseHistogram does a fast, subsampled pass over hds/files'
+// particles within [rMin, rMax] of origin, binning their count into a
+// histBinsTheta x histBinsPhi (theta, phi) histogram suitable for
+// los.NewImportanceSampler. stride skips stride-1 particles between each
+// one binned, the same subsampling knob LoadDensities' own full pass uses,
+// trading angular resolution in the histogram for binning time (every
+// sheet is still read in full) -- this pass
+// only has to pick out where the halo's mass roughly lies, not resolve
+// its profile.
+func buildCoarseHistogram(
+	hds []io.SheetHeader, files []string, origin *geom.Vec, rMin, rMax float64,
+	stride, histBinsTheta, histBinsPhi int,
+) [][]float64 {
+	hist := make([][]float64, histBinsTheta)
+	for i := range hist {
+		hist[i] = make([]float64, histBinsPhi)
+	}
+	if stride < 1 {
+		stride = 1
+	}
+
+	ox, oy, oz := float64(origin[0]), float64(origin[1]), float64(origin[2])
+	rMin2, rMax2 := rMin*rMin, rMax*rMax
+
+	for i := range hds {
+		hd := &hds[i]
+		gw, sw := int(hd.GridWidth), int(hd.SegmentWidth)
+		xs := make([]rgeom.Vec, gw*gw*sw)
+		if err := io.ReadSheetPositionsChunked(files[i], 0, sw, xs); err != nil {
+			continue
+		}
+
+		for j := 0; j < len(xs); j += stride {
+			dx := float64(xs[j][0]) - ox
+			dy := float64(xs[j][1]) - oy
+			dz := float64(xs[j][2]) - oz
+			r2 := dx*dx + dy*dy + dz*dz
+			if r2 < rMin2 || r2 > rMax2 || r2 == 0 {
+				continue
+			}
+
+			r := math.Sqrt(r2)
+			v := geom.Vec{float32(dx / r), float32(dy / r), float32(dz / r)}
+			ti, pi := los.HistBin(v, histBinsTheta, histBinsPhi)
+			hist[ti][pi]++
+		}
+	}
+
+	return hist
+}
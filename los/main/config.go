@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// HaloSelection picks which halos in the Rockstar catalog ProcessHalo
+// should run over, by exactly one of three mutually exclusive modes.
+type HaloSelection struct {
+	// Mode is one of "rank", "rid", or "mass". Defaults to "rank".
+	Mode string
+
+	// "rank": process catalog ranks [RankLo, RankHi). RankHi <= 0 means
+	// "through the end of the catalog."
+	RankLo, RankHi int
+
+	// "rid": process exactly these Rockstar IDs.
+	RIDs []int
+
+	// "mass": process halos with MinMass <= M <= MaxMass. MaxMass <= 0
+	// means "no upper bound."
+	MinMass, MaxMass float64
+}
+
+// SelectHalos returns the catalog indices selected by s, given the
+// catalog's Rockstar IDs and masses.
+func (s *HaloSelection) SelectHalos(rids []int, ms []float64) []int {
+	switch s.Mode {
+	case "rid":
+		wanted := make(map[int]bool, len(s.RIDs))
+		for _, rid := range s.RIDs {
+			wanted[rid] = true
+		}
+		idxs := []int{}
+		for i, rid := range rids {
+			if wanted[rid] {
+				idxs = append(idxs, i)
+			}
+		}
+		return idxs
+	case "mass":
+		idxs := []int{}
+		for i, m := range ms {
+			if m < s.MinMass {
+				continue
+			}
+			if s.MaxMass > 0 && m > s.MaxMass {
+				continue
+			}
+			idxs = append(idxs, i)
+		}
+		return idxs
+	default:
+		lo, hi := s.RankLo, s.RankHi
+		if lo < 0 {
+			lo = 0
+		}
+		if hi <= 0 || hi > len(rids) {
+			hi = len(rids)
+		}
+		idxs := make([]int, 0, hi-lo)
+		for i := lo; i < hi; i++ {
+			idxs = append(idxs, i)
+		}
+		return idxs
+	}
+}
+
+// Config is the batch-runner configuration that used to be hard-coded as
+// package constants and a TotalWidth-keyed ranks switch: which
+// directories to read/write, which halos to process, and every knob the
+// los.HaloProfiles/analyze.PennaPlaneFit pipeline takes.
+type Config struct {
+	InputDir string
+	HaloFile string
+	PlotDir  string
+	TextDir  string
+	SaveDir  string
+
+	Selection HaloSelection
+
+	RBins         int
+	Spokes        int
+	Rings         int
+	RefRingCounts []int
+
+	OrderI, OrderJ int
+
+	Window int
+	Cutoff float64
+
+	// KDELevels is the KDE-tree depth analyze.FilterPoints refines its
+	// splashback-point filter to for each reference realization; the first
+	// realization's filtered points are what writeShellHDF5 stores as its
+	// fit_xs/fit_ys datasets.
+	KDELevels int
+
+	RMinMult, RMaxMult float64
+	LogProfile         bool
+
+	// SubsampleLength skips SubsampleLength-1 particles between each one
+	// read when loading densities (and when building the coarse
+	// anisotropy histogram below), trading position resolution for I/O
+	// time.
+	SubsampleLength int
+
+	// HistBinsTheta/HistBinsPhi size the coarse (theta, phi) mass
+	// histogram ProcessHalo builds before drawing reference-realization
+	// orientations, so importanceAxes can bias those draws toward
+	// directions where the halo's mass is actually concentrated (see
+	// los.NewImportanceSampler).
+	HistBinsTheta, HistBinsPhi int
+
+	// RotationSeed seeds the reference-orientation rotations ProcessHalo
+	// draws for a halo. Runs with the same seed and the same RID resume
+	// cleanly via the checkpoint file (see checkpoint.go).
+	RotationSeed int64
+
+	FinderCells int
+	OverlapMult float64
+
+	// Workers is how many halos ProcessHalo runs concurrently. <= 1
+	// means a single worker goroutine, so halos run one at a time.
+	Workers int
+
+	// CacheMB bounds the shared sheet cache's size; halos processed by
+	// different workers that intersect the same sheet file reuse its
+	// decoded payload instead of re-reading it from disk. <= 0 disables
+	// the cache.
+	CacheMB int
+
+	// Output selects how each halo's Penna coefficients, per-ring
+	// splashback points, and ensemble stats are recorded: "hdf5" writes
+	// SaveDir/shells.hdf5 (see shell_writer.go); "text" or "" keeps the
+	// existing stdout/ensemble_stats.txt dumps.
+	Output string
+}
+
+// DefaultConfig returns the values this package used to bake in as
+// constants, so a config file only needs to override what it wants to
+// change.
+func DefaultConfig() *Config {
+	return &Config{
+		RBins:         256,
+		Spokes:        124,
+		Rings:         25,
+		RefRingCounts: []int{25},
+
+		OrderI: 3,
+		OrderJ: 3,
+
+		Window:    121,
+		Cutoff:    0.0,
+		KDELevels: 3,
+
+		RMinMult:   0.5,
+		RMaxMult:   3.0,
+		LogProfile: true,
+
+		SubsampleLength: 1,
+		HistBinsTheta:   8,
+		HistBinsPhi:     16,
+
+		FinderCells: 150,
+		OverlapMult: 3,
+
+		Workers: 1,
+		CacheMB: 0,
+	}
+}
+
+// LoadConfig reads a JSON config file, overlaying it onto DefaultConfig
+// so fields the file omits keep their default values.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -6,7 +6,8 @@ import (
 	"math"
 	"runtime"
 	"sort"
-	
+	"sync"
+
 	"github.com/phil-mansfield/gotetra/los"
 	"github.com/phil-mansfield/gotetra/los/geom"
 	rgeom "github.com/phil-mansfield/gotetra/render/geom"
@@ -28,11 +29,22 @@ type Params struct {
 	Order, Window, Levels, SubsampleLength int
 	Cutoff float64
 
+	// Robustness params
+	Perturb int
+	Rho float64
+
+	// Streaming params
+	MaxMemMB int
+
 	// Alternate modes
 	MedianProfile, MeanProfile, SphericalProfile bool
 	SphericalProfilePoints int
 	SphericalProfileTriLinearPoints int
 	SphericalProfileTriCubicPoints int
+	TriCubicKernel string
+
+	// Output format
+	Output string
 }
 
 func main() {
@@ -44,11 +56,19 @@ func main() {
 
 	if len(ids) == 0 { log.Fatal("No input IDs.") }
 
+	// Every snapshot in a single gtet_shell run shares one box width and
+	// cosmology, so a single header read (of whichever snapshot the first
+	// halo falls in) is enough to attribute the output file.
+	hd0, _, err := util.ReadHeaders(firstRealSnap(snaps))
+	if err != nil { log.Fatal(err.Error()) }
+
 	// We're just going to do this part separately.
 	if p.SphericalProfile {
 		out, err := profile(ids, snaps, p)
 		if err != nil { log.Fatal(err.Error()) }
-		util.PrintRows(ids, snaps, out)
+		if err := printOrWriteRows(ids, snaps, out, &hd0[0], p); err != nil {
+			log.Fatal(err.Error())
+		}
 		return
 	}
 	
@@ -72,6 +92,9 @@ func main() {
 		rowLength = p.RBins * 2
 	case p.MeanProfile:
 		rowLength = p.RBins * 2
+	case p.Perturb > 0:
+		// Coefficient vector followed by its per-coefficient scatter.
+		rowLength = p.Order*p.Order*2*2
 	default:
 		rowLength = p.Order*p.Order*2
 	}
@@ -92,9 +115,11 @@ MainLoop:
 		
 		if err != nil { log.Fatal(err.Error()) }
 		if losBuf == nil {
-			losBuf = los.NewBuffers(files[0], &hds[0], p.SubsampleLength)
+			losBuf = los.NewBuffers(
+				files[0], &hds[0], los.SubsampleLength(p.SubsampleLength),
+			)
 		}
-		halos, err := createHalos(snap, &hds[0], snapIDs, p)
+		halos, origins, r200m, err := createHalos(snap, &hds[0], snapIDs, p)
 		for i := range halos {
 			// Screw it, we're too early in the catalog. Abort!
 			if !halos[i].IsValid { continue MainLoop }
@@ -135,6 +160,18 @@ MainLoop:
 				runtime.GC()
 				out[idxs[i]] = calcMean(&halos[i], p)
 			}
+		} else if p.Perturb > 0 {
+			// Calculate Penna coefficients, refitting each halo p.Perturb
+			// times with a jittered origin to also report fit scatter.
+			for i := range halos {
+				runtime.GC()
+				cs, scatter, ok := perturbedCoeffs(
+					snapIDs[i], origins[i], r200m[i], hds, files, losBuf,
+					buf, p,
+				)
+				if !ok { log.Fatal("Welp, fix this.") }
+				out[idxs[i]] = append(cs, scatter...)
+			}
 		} else {
 			// Calculate Penna coefficients.
 			for i := range halos {
@@ -146,8 +183,22 @@ MainLoop:
 		}
 		
 	}
-	
-	util.PrintRows(ids, snaps, out)
+
+	if err := printOrWriteRows(ids, snaps, out, &hd0[0], p); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// firstRealSnap returns the first snapshot index in snaps that isn't the
+// "too early in the catalog" sentinel -1, for callers that just need any
+// valid snapshot's header (e.g. to attribute box width/cosmology on output).
+func firstRealSnap(snaps []int) int {
+	for _, snap := range snaps {
+		if snap != -1 {
+			return snap
+		}
+	}
+	return snaps[0]
 }
 
 func parseCmd() *Params {
@@ -177,6 +228,21 @@ func parseCmd() *Params {
 		"The number of particle edges per tetrahedron edge. Must be 2^n.")
 	flag.Float64Var(&p.Cutoff, "Cutoff", 0.0,
 		"The shallowest slope that can be considered a splashback point.")
+	flag.IntVar(&p.Perturb, "Perturb", 0,
+		"If non-zero, refit each halo's shell Perturb times with a jittered " +
+			"origin and resampled ring orientation, and report the median " +
+			"coefficient vector and per-coefficient scatter instead of a " +
+			"single fit.")
+	flag.Float64Var(&p.Rho, "Rho", 0.05,
+		"Radius of the origin jitter ball used by -Perturb, as a multiple " +
+			"of R_200m.")
+	flag.IntVar(&p.MaxMemMB, "MaxMemMB", 0,
+		"If non-zero, cap the memory used to hold each sheet's particle " +
+			"positions to roughly MaxMemMB megabytes by streaming it in " +
+			"z-slabs via io.ReadSheetPositionsChunked instead of loading " +
+			"the whole sheet at once. Only the -SphericalProfile particle " +
+			"and tetrahedron binning paths stream; the tri-linear/tri-cubic " +
+			"paths and shell fits still load whole sheets. 0 means no limit.")
 	flag.BoolVar(&p.MedianProfile, "MedianProfile", false,
 		"Compute the median halo profile instead of the shell. " + 
 			"KILL THIS OPTION.")
@@ -198,39 +264,68 @@ func parseCmd() *Params {
 		"SphericalProfileTriCubicPoints", 0,
 		"Number of particles per side of each cube when using tri-cubic " +
 			"interpolation. If 0, tri-cubic interpolation won't be used.")
+	flag.StringVar(&p.TriCubicKernel, "TriCubicKernel", "separable",
+		"Which kernel backs SphericalProfileTriCubicPoints: 'separable' " +
+			"(the default, a separable cubic that's only C^0 across cell " +
+			"boundaries) or 'hermite'/'lekien' (a tensor-product cubic " +
+			"Hermite interpolant with central-difference corner " +
+			"derivatives, C^1 across cell boundaries, evaluated by nested " +
+			"1-D Catmull-Rom passes; no 64-coefficient system is assembled " +
+			"or solved).")
+	flag.StringVar(&p.Output, "Output", "text",
+		"Output format for the halo catalog: 'text' or 'hdf5'.")
 	flag.Parse()
 	return p
 }
 
+// createHalos also returns each halo's origin and R_200m so that callers
+// doing -Perturb robustness refits can jitter them without re-reading the
+// Rockstar catalog.
 func createHalos(
 	snap int, hd *io.SheetHeader, ids []int, p *Params,
-) ([]los.HaloProfiles, error) {
+) ([]los.HaloProfiles, []geom.Vec, []float64, error) {
 	vals, err := util.ReadRockstar(
 		snap, ids, halo.X, halo.Y, halo.Z, halo.Rad200b,
 	)
-	if err != nil { return nil, err }
+	if err != nil { return nil, nil, nil, err }
 
 	xs, ys, zs, rs := vals[0], vals[1], vals[2], vals[3]
 	g := rand.NewTimeSeed(rand.Xorshift)
+	uniform01 := func() float64 { return g.Uniform(0, 1) }
+
+	// Re-randomized orientations below draw from a flat isotropic
+	// lattice, not a density-weighted one: this function has no coarse
+	// mass histogram available to bias toward (that only exists once a
+	// halo is far enough along in ProcessHalo's pipeline to have loaded
+	// densities; see los/main's buildCoarseHistogram).
+	norms, isoWeights := healpixSampler(1)
+	sampler := los.NewUniformSampler(norms, isoWeights)
 
 	// Initialize halos.
 	halos := make([]los.HaloProfiles, len(ids))
+	origins := make([]geom.Vec, len(ids))
 	seenIDs := make(map[int]bool)
 	for i, id := range ids {
-		origin := &geom.Vec{
+		origins[i] = geom.Vec{
 			float32(xs[i]), float32(ys[i]), float32(zs[i]),
 		}
+		origin := &origins[i]
 
 		if rs[i] <= 0 { continue }
-		
+
 		// If we've already seen a halo once, randomize its orientation.
+		// The axis is drawn from an isotropic lattice rather than
+		// independent Euler angles (see importanceAxes) so repeated
+		// realizations of the same halo aren't pole-biased.
 		if seenIDs[id] {
+			axes, _ := importanceAxes(1, uniform01, sampler)
+			angleX, angleY, angleZ := eulerFromAxis(
+				axes[0], 2*math.Pi*uniform01(),
+			)
 			halos[i].Init(
 				id, p.Rings, origin, rs[i] * p.MinMult, rs[i] * p.MaxMult,
 				p.RBins, p.Spokes, hd.TotalWidth, los.Log(true),
-				los.Rotate(float32(g.Uniform(0, 2 * math.Pi)),
-                    float32(g.Uniform(0, 2 * math.Pi)),
-                    float32(g.Uniform(0, 2 * math.Pi))),
+				los.Rotate(angleX, angleY, angleZ),
 			)
 		} else {
 			seenIDs[id] = true
@@ -241,7 +336,7 @@ func createHalos(
 		}
 	}
 
-	return halos, nil
+	return halos, origins, rs, nil
 }
 
 type profileRange struct {
@@ -280,6 +375,7 @@ func profile(ids, snaps []int, p *Params) ([][]float64, error) {
 	// tetra and tri-linear setup.
 	var (
 		xs []rgeom.Vec
+		chunkZ int
 		vecBuf []rgeom.Vec
 		randBuf []float64
 		gen *rand.Generator
@@ -288,6 +384,7 @@ func profile(ids, snaps []int, p *Params) ([][]float64, error) {
 		intrBuf *intrBuffers
 		con intrConstructor
 		triPts int
+		triX, triY, triZ intr.TriInterpolator
 	)
 
 	if p.SphericalProfilePoints > 0 {
@@ -305,12 +402,23 @@ func profile(ids, snaps []int, p *Params) ([][]float64, error) {
 		}
 	} else if p.SphericalProfileTriCubicPoints > 0 {
 		triPts = p.SphericalProfileTriCubicPoints
-		con = func(x0, dx float64, nx int,
-			y0, dy float64, ny int, 
-			z0, dz float64, nz int, vals []float64) intr.TriInterpolator {
-				return intr.NewUniformTriCubic(
-					x0, dx, nx, y0, dy, ny, z0, dz, nz, vals,
-				)
+		switch p.TriCubicKernel {
+		case "hermite", "lekien":
+			con = func(x0, dx float64, nx int,
+				y0, dy float64, ny int,
+				z0, dz float64, nz int, vals []float64) intr.TriInterpolator {
+					return intr.NewUniformTriCubicHermite(
+						x0, dx, nx, y0, dy, ny, z0, dz, nz, vals,
+					)
+			}
+		default:
+			con = func(x0, dx float64, nx int,
+				y0, dy float64, ny int,
+				z0, dz float64, nz int, vals []float64) intr.TriInterpolator {
+					return intr.NewUniformTriCubic(
+						x0, dx, nx, y0, dy, ny, z0, dz, nz, vals,
+					)
+			}
 		}
 	}
 
@@ -324,8 +432,25 @@ func profile(ids, snaps []int, p *Params) ([][]float64, error) {
 		hds, files, err := util.ReadHeaders(snap)
 		if err != nil { return nil, err }
 		if len(xs) == 0 {
-			n := hds[0].GridWidth*hds[0].GridWidth*hds[0].GridWidth
-			xs = make([]rgeom.Vec, n)
+			gw, sw := int(hds[0].GridWidth), int(hds[0].SegmentWidth)
+
+			if triPts > 0 {
+				// The interpolator stencil needs simultaneous access to
+				// the whole segment's z-range, so -MaxMemMB doesn't chunk
+				// this path.
+				chunkZ = sw
+			} else {
+				chunkZ = chunkZDepth(gw, p.MaxMemMB)
+			}
+
+			// When streaming the tetra path in z-chunks, load one extra
+			// skip-wide guard plane past each chunk's end so the last
+			// slab's forward tetra neighbor is already in the buffer.
+			guard := 0
+			if p.SphericalProfilePoints > 0 && chunkZ < sw {
+				guard = p.SubsampleLength
+			}
+			xs = make([]rgeom.Vec, gw*gw*(chunkZ+guard))
 
 			if p.SphericalProfile {
 				profs = make([]*sphericalProfile, len(ranges))
@@ -337,9 +462,16 @@ func profile(ids, snaps []int, p *Params) ([][]float64, error) {
 
 			if triPts > 0 {
 				intrBuf = newIntrBuffers(
-					int(hds[0].SegmentWidth), 
+					int(hds[0].SegmentWidth),
 					int(hds[0].GridWidth), p.SubsampleLength,
 				)
+				// Build these once and reuse them for every sheet: con's
+				// interpolators read straight out of intrBuf.xs/ys/zs, so
+				// there's no need to reallocate them every time those
+				// buffers are refilled.
+				triX = con(0, 1, intrBuf.kw, 0, 1, intrBuf.kw, 0, 1, intrBuf.kw, intrBuf.xs)
+				triY = con(0, 1, intrBuf.kw, 0, 1, intrBuf.kw, 0, 1, intrBuf.kw, intrBuf.ys)
+				triZ = con(0, 1, intrBuf.kw, 0, 1, intrBuf.kw, 0, 1, intrBuf.kw, intrBuf.zs)
 			}
 		}
 		
@@ -348,20 +480,39 @@ func profile(ids, snaps []int, p *Params) ([][]float64, error) {
 			if len(intrBins[i]) == 0 { continue }
 			log.Printf("%d%d%d -> (%d)", i / 64, (i / 8) % 8, i % 8,
 				len(intrBins[i]))
-			err := io.ReadSheetPositionsAt(files[i], xs)
-			if err != nil { return nil, err }
-			for _, j := range intrBins[i] {
-				if p.SphericalProfilePoints > 0 {
-					tetraBinParticles(
-						&hds[i], xs, p.SubsampleLength, profs[idxs[j]],
-						vecBuf, randBuf, gen,
-					)
-				} else if triPts > 0 {
-					interpolatorBinParticles(
-						xs, triPts, profs[idxs[j]], con, intrBuf,
-					)
-				} else {
-					binParticles(&hds[i], xs, p.SubsampleLength, profs[idxs[j]])
+
+			sw := int(hds[i].SegmentWidth)
+			for zStart := 0; zStart < sw; zStart += chunkZ {
+				zEnd := zStart + chunkZ
+				if zEnd > sw { zEnd = sw }
+
+				loadEnd := zEnd
+				if p.SphericalProfilePoints > 0 && zEnd < sw {
+					loadEnd += p.SubsampleLength
+					if loadEnd > sw { loadEnd = sw }
+				}
+
+				err := io.ReadSheetPositionsChunked(
+					files[i], zStart, loadEnd, xs,
+				)
+				if err != nil { return nil, err }
+
+				for _, j := range intrBins[i] {
+					if p.SphericalProfilePoints > 0 {
+						tetraBinParticles(
+							&hds[i], xs, zStart, zEnd, p.SubsampleLength,
+							profs[idxs[j]], vecBuf, randBuf, gen,
+						)
+					} else if triPts > 0 {
+						interpolatorBinParticles(
+							xs, triPts, profs[idxs[j]], triX, triY, triZ, intrBuf,
+						)
+					} else {
+						binParticles(
+							&hds[i], xs, zStart, zEnd, p.SubsampleLength,
+							profs[idxs[j]],
+						)
+					}
 				}
 			}
 		}
@@ -403,23 +554,74 @@ func countsToRhos(prof *sphericalProfile, skip, tetraPoints, triPoints int) {
 	}
 }
 
+// tetraBinParticles deposits the tetrahedral decomposition of the
+// [zStart, zEnd) z-slabs of xs into prof, splitting them across
+// runtime.NumCPU() goroutines via zSplit so that every worker gets a
+// roughly even share of work. xs is indexed locally, offset by zStart,
+// since -MaxMemMB may mean it only holds this chunk of the sheet's full
+// z range rather than the whole thing (see chunkZDepth). Each worker
+// accumulates into its own private sphericalProfile.counts buffer, which
+// are summed into prof once every worker finishes.
 func tetraBinParticles(
-	hd *io.SheetHeader, xs []rgeom.Vec, skip int, prof *sphericalProfile,
-	vecBuf []rgeom.Vec, randBuf []float64, gen *rand.Generator,
+	hd *io.SheetHeader, xs []rgeom.Vec, zStart, zEnd, skip int,
+	prof *sphericalProfile, vecBuf []rgeom.Vec, randBuf []float64,
+	gen *rand.Generator,
 ) {
 	sw, gw := int(hd.SegmentWidth), int(hd.GridWidth)
-	for iz := 0; iz < sw; iz += skip {
-		for iy := 0; iy < sw; iy += skip {
-			for ix := 0; ix < sw; ix += skip {
-				idx := ix + gw*iy + gw*gw*iz
-				for dir := 0; dir < 6; dir++ {
-					tetraPoints(idx, dir, gw, skip, xs, gen, randBuf, vecBuf)
-					for _, pt := range vecBuf {
-						x := float64(pt[0])
-						y := float64(pt[1])
-						z := float64(pt[2])
-						prof.insert(x, y, z)
-					}
+	sliceWidth := (zEnd - zStart) / skip
+
+	zCounts := make([]int, sliceWidth)
+	for i := range zCounts { zCounts[i] = sw * sw / (skip * skip) }
+
+	workers := runtime.NumCPU()
+	if workers > sliceWidth { workers = sliceWidth }
+	if workers < 1 { workers = 1 }
+	workerSlabs := zSplit(zCounts, workers)
+
+	profs := make([]*sphericalProfile, workers)
+	wg := sync.WaitGroup{}
+	for w := range workerSlabs {
+		profs[w] = prof.emptyClone()
+		workerGen := rand.NewTimeSeed(rand.Xorshift)
+		workerVecBuf := make([]rgeom.Vec, len(vecBuf))
+		workerRandBuf := make([]float64, len(randBuf))
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for _, zi := range workerSlabs[w] {
+				iz := zStart + zi*skip
+				tetraBinZSlab(
+					iz, zStart, sw, gw, skip, xs, workerGen,
+					workerRandBuf, workerVecBuf, profs[w],
+				)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, p := range profs {
+		prof.merge(p)
+	}
+}
+
+// tetraBinZSlab deposits the single z-slab beginning at the absolute grid
+// coordinate iz. xs is indexed locally, offset by zStart, per the comment
+// on tetraBinParticles.
+func tetraBinZSlab(
+	iz, zStart, sw, gw, skip int, xs []rgeom.Vec, gen *rand.Generator,
+	randBuf []float64, vecBuf []rgeom.Vec, prof *sphericalProfile,
+) {
+	for iy := 0; iy < sw; iy += skip {
+		for ix := 0; ix < sw; ix += skip {
+			idx := ix + gw*iy + gw*gw*(iz-zStart)
+			for dir := 0; dir < 6; dir++ {
+				tetraPoints(idx, dir, gw, skip, xs, gen, randBuf, vecBuf)
+				for _, pt := range vecBuf {
+					x := float64(pt[0])
+					y := float64(pt[1])
+					z := float64(pt[2])
+					prof.insert(x, y, z)
 				}
 			}
 		}
@@ -648,7 +850,23 @@ func (sp *sphericalProfile) contains(x, y, z float64) bool {
 	return sp.rMin2 < r2 && r2 < sp.rMax2
 }
 
-// insert inserts the given point into the profile with the given weight 
+// emptyClone returns a new sphericalProfile with the same geometry as sp but
+// a fresh, zeroed counts buffer. Used to give each parallel binning worker
+// its own private accumulator.
+func (sp *sphericalProfile) emptyClone() *sphericalProfile {
+	clone := *sp
+	clone.counts = make([]float64, len(sp.counts))
+	return &clone
+}
+
+// merge adds other's counts into sp's, bin by bin.
+func (sp *sphericalProfile) merge(other *sphericalProfile) {
+	for i := range sp.counts {
+		sp.counts[i] += other.counts[i]
+	}
+}
+
+// insert inserts the given point into the profile with the given weight
 // if possible. If the point is inserted true is returned, otherwise false is
 // returned.
 func (sp *sphericalProfile) insert(x, y, z float64) bool {
@@ -665,43 +883,89 @@ var hits = 0
 var passes = 0
 
 // interpolatorBinParticles places the density field represented by the given
-// points into the given profile.
+// points into the given profile, using triX/triY/triZ (built once per
+// snapshot and reused across every sheet and halo) to interpolate within the
+// cells buf.loadVecs marks as intersecting prof.
+//
+// Work is split across runtime.NumCPU() goroutines by z-slab via zSplit,
+// using the same load-balancing the slab already needed for zCounts. Each
+// worker accumulates into its own private sphericalProfile and the results
+// are merged into prof once every worker is done.
 func interpolatorBinParticles(
 	vecs []rgeom.Vec, pts int, prof *sphericalProfile,
-	con intrConstructor, buf *intrBuffers,
+	triX, triY, triZ intr.TriInterpolator, buf *intrBuffers,
 ) {
 	prof.transform(vecs)
 	buf.loadVecs(vecs, prof)
 
-	// Yup... lots of allocations happening here... -___-
-	// This could be improved.
-	runtime.GC()
-
-	triX := con(0, 1, buf.kw, 0, 1, buf.kw, 0, 1, buf.kw, buf.xs)
-	triY := con(0, 1, buf.kw, 0, 1, buf.kw, 0, 1, buf.kw, buf.ys)
-	triZ := con(0, 1, buf.kw, 0, 1, buf.kw, 0, 1, buf.kw, buf.zs)
-	
-	xBuf := make([]int, 0, buf.kw*buf.kw)
-	yBuf := make([]int, 0, buf.kw*buf.kw)
-	
-	i := 0
-	for z := 0; z < buf.kw-1; z++ {
-		xBuf := xBuf[0:0]
-		yBuf := yBuf[0:0]
-		for y := 0; y < buf.kw-1; y++ {
-			for x := 0; x < buf.kw-1; x++ {
-				if buf.boxIntr[i] {
-					xBuf = append(xBuf, x)
-					yBuf = append(yBuf, y)
+	zCounts := buf.zCounts()
+	workers := runtime.NumCPU()
+	if workers > len(zCounts) { workers = len(zCounts) }
+	if workers < 1 { workers = 1 }
+	workerSlabs := zSplit(zCounts, workers)
+
+	profs := make([]*sphericalProfile, workers)
+	wg := sync.WaitGroup{}
+	for w := range workerSlabs {
+		profs[w] = prof.emptyClone()
+
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// triX/triY/triZ are shared across every worker (and every
+			// sheet/halo, see profile()); interpolators with mutable
+			// per-call state (triCubicHermite's corner cache) need a
+			// private copy per goroutine so concurrent Eval calls don't
+			// race on it. Stateless interpolators (triLinear, triCubic)
+			// don't implement intr.Cloner and are used directly.
+			wTriX, wTriY, wTriZ := cloneInterpolator(triX),
+				cloneInterpolator(triY), cloneInterpolator(triZ)
+
+			xBuf := make([]int, 0, buf.kw*buf.kw)
+			yBuf := make([]int, 0, buf.kw*buf.kw)
+			for _, z := range workerSlabs[w] {
+				xBuf, yBuf = intersectingXY(buf, z, xBuf, yBuf)
+				if len(xBuf) > 0 {
+					xyInterpolate(xBuf, yBuf, z, wTriX, wTriY, wTriZ, pts, profs[w])
 				}
-				i++
 			}
-		}
-		
-		if len(xBuf) > 0 {
-			xyInterpolate(xBuf, yBuf, z, triX, triY, triZ, pts, prof)
+		}(w)
+	}
+	wg.Wait()
+
+	for _, p := range profs {
+		prof.merge(p)
+	}
+}
+
+// cloneInterpolator returns ti's own private copy if it implements
+// intr.Cloner (see triCubicHermite's corner cache), otherwise ti itself,
+// since interpolators without mutable state are already safe to share
+// across the goroutines interpolatorBinParticles fans out.
+func cloneInterpolator(ti intr.TriInterpolator) intr.TriInterpolator {
+	if c, ok := ti.(intr.Cloner); ok {
+		return c.Clone()
+	}
+	return ti
+}
+
+// intersectingXY returns the (x, y) cell indices of the z-layer z that
+// buf.boxIntr marks as intersecting the halo, appending them to the given
+// buffers (which are reset to length 0 first).
+func intersectingXY(buf *intrBuffers, z int, xBuf, yBuf []int) ([]int, []int) {
+	xBuf, yBuf = xBuf[:0], yBuf[:0]
+	rowWidth := (buf.kw - 1) * (buf.kw - 1)
+	i := z * rowWidth
+	for y := 0; y < buf.kw-1; y++ {
+		for x := 0; x < buf.kw-1; x++ {
+			if buf.boxIntr[i] {
+				xBuf = append(xBuf, x)
+				yBuf = append(yBuf, y)
+			}
+			i++
 		}
 	}
+	return xBuf, yBuf
 }
 
 func xyInterpolate(
@@ -761,15 +1025,20 @@ func tetraPoints(
 	tet.RandomSample(gen, randBuf, vecBuf)
 }
 
+// binParticles deposits the particles in the [zStart, zEnd) z-slabs of xs
+// into prof. xs is indexed locally, offset by zStart, since -MaxMemMB may
+// mean it only holds this chunk of the sheet's full z range (see
+// chunkZDepth).
 func binParticles(
-	hd *io.SheetHeader, xs []rgeom.Vec, skip int, prof *sphericalProfile,
+	hd *io.SheetHeader, xs []rgeom.Vec, zStart, zEnd, skip int,
+	prof *sphericalProfile,
 ) {
 	prof.transform(xs)
 	sw, gw := int(hd.SegmentWidth), int(hd.GridWidth)
-	for iz := 0; iz < sw; iz += skip {
+	for iz := zStart; iz < zEnd; iz += skip {
 		for iy := 0; iy < sw; iy += skip {
 			for ix := 0; ix < sw; ix += skip {
-				pt := xs[ix + iy*gw + iz*gw*gw]
+				pt := xs[ix + iy*gw + (iz-zStart)*gw*gw]
 				x, y, z := float64(pt[0]), float64(pt[1]), float64(pt[2])
 				prof.insert(x, y, z)
 			}
@@ -817,6 +1086,25 @@ func inRange(x, r, low, width, tw float32) bool {
 	return wrapDist(x, low, tw) > -r && wrapDist(x, low + width, tw) < r
 }
 
+// vecBytes is the in-memory size of a render/geom.Vec (3 float32s), used
+// by chunkZDepth to size -MaxMemMB's z-slab chunks.
+const vecBytes = 12
+
+// chunkZDepth returns how many z-planes of a gw x gw sheet fit in
+// maxMemMB megabytes, so that -MaxMemMB controls the depth of the z-slab
+// io.ReadSheetPositionsChunked fills in at a time. A maxMemMB of 0 means
+// "no limit": the whole sheet is read in one chunk, matching the
+// pre-streaming behavior.
+func chunkZDepth(gw, maxMemMB int) int {
+	if maxMemMB <= 0 || gw <= 0 { return gw }
+	maxBytes := int64(maxMemMB) * 1000000
+	perPlane := int64(gw) * int64(gw) * vecBytes
+	n := int(maxBytes / perPlane)
+	if n < 1 { n = 1 }
+	if n > gw { n = gw }
+	return n
+}
+
 func wrapDist(x1, x2, width float32) float32 {
 	dist := x1 - x2
 	if dist > width / 2 {
@@ -855,6 +1143,120 @@ func calcCoeffs(
 	return cs, true
 }
 
+// perturbedCoeffs refits a halo's shell p.Perturb times, each time jittering
+// the origin inside a ball of radius p.Rho*r200m and resampling the ring
+// orientation, and returns the per-coefficient median and scatter (sample
+// standard deviation) across the resulting coefficient vectors. This exposes
+// how sensitive the Penna fit is to small centering errors, which a single
+// fit can't reveal and which is a real issue for halos caught near mergers.
+func perturbedCoeffs(
+	id int, origin geom.Vec, r200m float64,
+	hds []io.SheetHeader, files []string, losBuf *los.Buffers,
+	buf []analyze.RingBuffer, p *Params,
+) ([]float64, []float64, bool) {
+	g := rand.NewTimeSeed(rand.Xorshift)
+	uniform01 := func() float64 { return g.Uniform(0, 1) }
+
+	// Each perturbation trial only cares about one refit's own scatter,
+	// not an importance-weighted ensemble average, so a flat isotropic
+	// draw (no density-adaptive bias) is the right sampler here.
+	norms, isoWeights := healpixSampler(1)
+	sampler := los.NewUniformSampler(norms, isoWeights)
+
+	samples := make([][]float64, 0, p.Perturb)
+	for n := 0; n < p.Perturb; n++ {
+		dx, dy, dz := sampleInBall(p.Rho * r200m, g)
+		jittered := geom.Vec{
+			origin[0] + float32(dx),
+			origin[1] + float32(dy),
+			origin[2] + float32(dz),
+		}
+
+		// As in createHalos, draw the refit orientation's axis from an
+		// isotropic lattice instead of three independent Euler angles.
+		axes, _ := importanceAxes(1, uniform01, sampler)
+		angleX, angleY, angleZ := eulerFromAxis(axes[0], 2*math.Pi*uniform01())
+
+		trial := []los.HaloProfiles{ {} }
+		trial[0].Init(
+			id, p.Rings, &jittered, r200m * p.MinMult, r200m * p.MaxMult,
+			p.RBins, p.Spokes, hds[0].TotalWidth, los.Log(true),
+			los.Rotate(angleX, angleY, angleZ),
+		)
+
+		intrBins := binIntersections(hds, trial)
+		hdContainer := make([]io.SheetHeader, 1)
+		fileContainer := make([]string, 1)
+		for i := range hds {
+			if len(intrBins[i]) == 0 { continue }
+			hdContainer[0] = hds[i]
+			fileContainer[0] = files[i]
+			los.LoadPtrDensities(
+				intrBins[i], hdContainer, fileContainer, losBuf,
+			)
+		}
+
+		cs, ok := calcCoeffs(&trial[0], buf, p)
+		if !ok { continue }
+		samples = append(samples, cs)
+	}
+	if len(samples) == 0 { return nil, nil, false }
+
+	n := len(samples[0])
+	median := make([]float64, n)
+	scatter := make([]float64, n)
+	column := make([]float64, len(samples))
+	for j := 0; j < n; j++ {
+		for i, cs := range samples { column[i] = cs[j] }
+		median[j] = medianOf(column)
+		scatter[j] = stddevOf(column, median[j])
+	}
+	return median, scatter, true
+}
+
+// sampleInBall draws a point uniformly distributed within a ball of the
+// given radius centered on the origin: a radius r = radius*U^(1/3) times a
+// unit vector drawn from three independent standard-normal samples.
+func sampleInBall(radius float64, g *rand.Generator) (dx, dy, dz float64) {
+	r := radius * math.Cbrt(g.Uniform(0, 1))
+	vx, vy, vz := gaussianSample(g), gaussianSample(g), gaussianSample(g)
+	norm := math.Sqrt(vx*vx + vy*vy + vz*vz)
+	if norm == 0 { return 0, 0, 0 }
+	return r * vx / norm, r * vy / norm, r * vz / norm
+}
+
+// gaussianSample draws a single standard-normal sample from g via the
+// Box-Muller transform.
+func gaussianSample(g *rand.Generator) float64 {
+	u1, u2 := g.Uniform(1e-300, 1), g.Uniform(0, 1)
+	return math.Sqrt(-2 * math.Log(u1)) * math.Cos(2 * math.Pi * u2)
+}
+
+// medianOf returns the median of xs, copying it first so the caller's slice
+// order is left untouched.
+func medianOf(xs []float64) float64 {
+	sorted := make([]float64, len(xs))
+	copy(sorted, xs)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted) % 2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// stddevOf returns the sample standard deviation of xs about the given
+// center.
+func stddevOf(xs []float64, center float64) float64 {
+	if len(xs) < 2 { return 0 }
+	sum := 0.0
+	for _, x := range xs {
+		d := x - center
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(xs) - 1))
+}
+
 func calcMedian(halo *los.HaloProfiles, p *Params) []float64 {
 	rs := make([]float64, p.RBins)
 	halo.GetRs(rs)
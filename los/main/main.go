@@ -12,7 +12,7 @@ import (
 	"strings"
 	"runtime/pprof"
 
-	"github.com/phil-mansfield/gotetra/render/io"	
+	"github.com/phil-mansfield/gotetra/render/io"
 	"github.com/phil-mansfield/gotetra/render/halo"
 
 	"github.com/phil-mansfield/gotetra/los"
@@ -24,23 +24,11 @@ import (
 
 const (
 	rType = halo.R200m
-	rMaxMult = 3.0
-	rMinMult = 0.5
 
-	n = 124
-	bins = 256
-	window = 121
-	cutoff = 0.0
-
-	rings = 25
-	plotStart = 8
-	plotCount = 1
-
-	I, J = 3, 3
-	
-	// SubhaloFinder params.
-	finderCells = 150
-	overlapMult = 3
+	// defaultSpokes mirrors DefaultConfig's Spokes value. visProfs only
+	// needs a plausible upper bound to pick a few representative LoS
+	// indices to highlight in plots.
+	defaultSpokes = 124
 
 	hdSaveFile = "hdSave.dat"
 )
@@ -50,23 +38,9 @@ var (
 		"DarkSlateBlue", "DarkSlateGray", "DarkTurquoise",
 		"DarkViolet", "DeepPink", "DimGray",
 	}
-	refRings = []int{
-		25, //10, 10, 10, 10, 10,
-		//20, 20, 20, 20, 20, 20,
-		//20, 20, 20, 20, 20, 20,
-		//20, 20, 20, 20, 20, 20,
-		//20, 20, 20, 20, 20, 20,
-		//20, 20, 20, 20, 20, 20,
-		//40, 40, 40, 40, 40, 40,
-		//40, 40, 40, 40, 40, 40,
-		//40, 40, 40, 40, 40, 40,
-		//40, 40, 40, 40, 40, 40,
-		//3, 4, 6, 10,
-	}
-	refHalos = len(refRings)
 	visProfs = []int{
-		rand.Intn(n), rand.Intn(n), rand.Intn(n),
-	//	rand.Intn(n), rand.Intn(n), rand.Intn(n),
+		rand.Intn(defaultSpokes), rand.Intn(defaultSpokes),
+		rand.Intn(defaultSpokes),
 	}
 )
 
@@ -80,7 +54,7 @@ func loadHeaders(files []string, saveDir string) ([]io.SheetHeader, error) {
 		defer f.Close()
 		fmt.Println("Loading saved headers.")
 		binary.Read(f, binary.LittleEndian, hds)
-		
+
 	} else {
 		fmt.Print("Loading individual headers: ")
 
@@ -103,36 +77,31 @@ func loadHeaders(files []string, saveDir string) ([]io.SheetHeader, error) {
 func main() {
 	// Argument Parsing.
 	fmt.Println("Running")
-	if len(os.Args) != 6 {
-		log.Fatalf("Usage: $ %s input_dir halo_file plot_dir text_dir save_dir",
-			os.Args[0])
+	if len(os.Args) != 2 {
+		log.Fatalf("Usage: $ %s config.json", os.Args[0])
 	}
 
-	dirName := os.Args[1]
-	haloFileName := os.Args[2]
-	plotDir := os.Args[3]
-	textDir := os.Args[4]
-	saveDir := os.Args[5]
+	cfg, err := LoadConfig(os.Args[1])
+	if err != nil { log.Fatal(err.Error()) }
 
 	// Do I/O and set up buffers.
-	files, err := fileNames(dirName)
+	files, err := fileNames(cfg.InputDir)
 	if err != nil { log.Fatal(err.Error()) }
-	hds, err := loadHeaders(files, saveDir)
+	hds, err := loadHeaders(files, cfg.SaveDir)
 	if err != nil { log.Fatal(err.Error()) }
-	buf := los.NewBuffers(files[0], &hds[0])
 	fmt.Println("Loaded headers")
 
 	// Find halos, subhalos, etc.
 	rids, xs, ys, zs, ms, rs, err := halo.ReadRockstar(
-		haloFileName, rType, &hds[0].Cosmo,
+		cfg.HaloFile, rType, &hds[0].Cosmo,
 	)
 
 	if err != nil { log.Fatal(err.Error()) }
 	fmt.Println("Read halos")
-	g := halo.NewGrid(finderCells, hds[0].TotalWidth, len(xs))
+	g := halo.NewGrid(cfg.FinderCells, hds[0].TotalWidth, len(xs))
 	g.Insert(xs, ys, zs)
 	sf := halo.NewSubhaloFinder(g)
-	sf.FindSubhalos(xs, ys, zs, rs, overlapMult)
+	sf.FindSubhalos(xs, ys, zs, rs, cfg.OverlapMult)
 	fmt.Println("Found subhalos")
 
 	// Profiling boilerplate.
@@ -141,112 +110,44 @@ func main() {
 	pprof.StartCPUProfile(f)
 	defer pprof.StopCPUProfile()
 
-	// Analyze each halo.
-	plotRs, plotRhos := make([]float64, bins), make([]float64, bins)
-
-	totRbs := make([][]analyze.RingBuffer, refHalos + 1)
-	totRbs[0] = make([]analyze.RingBuffer, rings)
-	rbs := totRbs[0]
-	rbRefs := totRbs[1:]
-	for j := range rbRefs {
-		rbRefs[j] = make([]analyze.RingBuffer, refRings[j])
-	}
-
-	for j := range totRbs {
-		for i := range totRbs[j] {
-			totRbs[j][i].Init(n, bins)
-		}
-	}
+	// Resume support: skip any (RID, seed) pair a previous, interrupted
+	// run already finished.
+	done, err := loadCheckpoint(cfg.SaveDir)
+	if err != nil { log.Fatal(err.Error()) }
 
-	//idx := -1
-	//for j, rid := range rids {
-	//	if rid == 166305652 { idx = j }
-	//}
-	//if idx == -1 {
-	//	panic("Couldn't find it.")
-	//}
-
-	//for i := plotStart; i < plotStart + plotCount; i++ {
-	//for _, i := range []int{
-	//	1001, 1006, 1008, 1009, 1014, 1017, 1018, 1033, 1047, 6006, 6030,
-	//} {
-
-	ranks := []int{}
-	switch hds[0].TotalWidth {
-	case 62.5:
-		ranks = []int{ 4302, 8092, 6862, 522, 4565, 2991, 4250 }
-	case 125.0:
-		ranks = []int{ 2019, 2189, 1568, 1268, 367, 1673, 276, 300 }
-	case 250.0:
-		ranks = []int{ 296, 201, 314, 236, 924, 1477, 521, 726, 339 }
-	case 500.0:
-		ranks = []int{ 8, 51, 105, 825, 465, 562, 902, 809, 241 }
-	default:
-		panic(fmt.Sprintf("recognized box with %g", hds[0].TotalWidth))
-	}
+	ds := &Dataset{Hds: hds, Files: files}
+	idxs := cfg.Selection.SelectHalos(rids, ms)
 
-	for _, i := range ranks {
-		fmt.Printf("Loading %d (%d)\n", i, rids[i])
-		if sf.HostCount(i) > 0 { 
-			fmt.Println("Ignoring halo with host.")
+	jobs := make([]haloJob, 0, len(idxs))
+	for _, i := range idxs {
+		if sf.HostCount(i) > 0 {
+			fmt.Printf("Ignoring halo %d with host.\n", rids[i])
 			continue
 		}
-		
-		origin := &geom.Vec{float32(xs[i]), float32(ys[i]), float32(zs[i])}
-
-		hs := make([]los.HaloProfiles, refHalos + 1)
-		h := &hs[0]
-		hRefs := hs[1:]
-
-		h.Init(i, rings, origin, rs[i] * rMinMult, rs[i] * rMaxMult,
-			bins, n, hds[0].TotalWidth, los.Log(true))
-		for j := range hRefs {
-			hRefs[j].Init(i, refRings[j], origin, rs[i] * rMinMult,
-				rs[i] * rMaxMult, bins, n, hds[0].TotalWidth, los.Log(true),
-				los.Rotate(float32(2 * math.Pi * rand.Float64()),
-					float32(2 * math.Pi * rand.Float64()),
-					float32(2 * math.Pi * rand.Float64())))
-		}
-		hdIntrs, fileIntrs := intersectingSheets(h, hds, files)		
-		
-		fmt.Println("Computing Densities")
-		los.LoadDensities(hs, hdIntrs, fileIntrs, buf)
-		for j := range totRbs {
-			for k := range totRbs[j] {
-				totRbs[j][k].Clear()
-				totRbs[j][k].Splashback(&hs[j], k, window, cutoff)
-			}
-		}
-
-		fmt.Println("Single Fit")
-		pShells := make([]analyze.ProjectedShell, len(hRefs))
-		shells := make([]analyze.Shell, len(hRefs))
-		
-		for j := range pShells {
-			pxs, pys, _ := analyze.FilterPoints(rbRefs[j], 3) 
-			cs, pShell := analyze.PennaPlaneFit(pxs, pys, &hRefs[j], I, J)
-			shell := analyze.PennaFunc(cs, I, J, 2)
-			v := shell.Volume(100 * 1000)
-			vRad := math.Pow(v / (4 * math.Pi / 3), 0.3333)
-			PrintCoeffs(cs, vRad, rs[i])
-			pShells[j], shells[j] = pShell, shell
-		}
-		pxs, pys, _ := analyze.FilterPoints(rbs, 3) 
-		cs, _ := analyze.PennaPlaneFit(pxs, pys, h, I, J)
-		_ = cs
-
-		for j, shell := range shells {
-			printShellStats(shell, h.ID(), j, 10 * 1000)
+		key := checkpointKey{RID: int32(rids[i]), Seed: cfg.RotationSeed}
+		if done[key] {
+			fmt.Printf("Halo %d already processed, skipping.\n", rids[i])
+			continue
 		}
+		jobs = append(jobs, haloJob{
+			idx: i, rid: rids[i], radius: rs[i],
+			origin: &geom.Vec{float32(xs[i]), float32(ys[i]), float32(zs[i])},
+		})
+	}
 
-		//for j := range rbRefs {
-		//	plotKde(rbRefs[j], ms[i], h.ID(), j, plotDir)
-		//}
+	cache := NewSheetCache(int64(cfg.CacheMB) * 1000 * 1000)
+	RunPool(cfg, ds, cache, jobs, cfg.Workers, func(jr haloJobResult) {
+		// Write this halo's output and mark it done in the checkpoint
+		// file as soon as it streams back from the pool, not after every
+		// job finishes, so an interrupted run can resume from here
+		// instead of redoing it.
+		if jr.err != nil { log.Fatal(jr.err.Error()) }
+		result, i := jr.result, jr.job.idx
 
-		sh := shells[0]
+		sh := result.Shell
 		v := sh.Volume(100 * 1000)
 		vRad := math.Pow(v / (4 * math.Pi / 3), 0.3333)
-		
+
 		rMin, rMax := sh.RadialRange(100 * 1000)
 		cv := sh.CartesianSampledVolume(100 * 1000, rMax)
 		cvRad := math.Pow(cv / (4 * math.Pi / 3), 0.3333)
@@ -257,19 +158,29 @@ func main() {
 			"rMin", "rMax", "vRad", "cvRad", "meanR", "medR")
 		fmt.Printf("%8.3g %8.3g %8.3g %8.3g %8.3g %8.3g\n",
 			rMin, rMax, vRad, cvRad, meanR, medR)
-		
-		fmt.Println("Plotting Tracers")
-		//plotTracers(hRefs, rbRefs, h.ID(), 1, 1000, plotDir)
-		fmt.Println("Plotting Plane")
-		for ring := 0; ring < rings; ring++ {
-			plotPlane(h, &rbs[ring], ms[i], h.ID(),
-				ring, pShells, medR, plotDir, textDir)
-			_, _ = plotRhos, plotRs
-			//plotExampleProfiles(h, ms[i], ring, plotRs, plotRhos, plotDir)
-			//plotExampleDerivs(h, ms[i], ring, plotRs, plotRhos, plotDir)
+
+		// writeShellOutput above already recorded this halo's fit
+		// points/coeffs/stats in cfg.SaveDir/shells.hdf5 when
+		// cfg.Output == "hdf5", so plotPlane's per-ring plots (and the
+		// text dumps they'd otherwise duplicate) are only worth
+		// generating in the legacy text-output mode.
+		if cfg.Output != "hdf5" {
+			fmt.Println("Plotting Plane")
+			for ring := 0; ring < cfg.Rings; ring++ {
+				plotPlane(
+					result.Halo, &result.Rings[ring], ms[i], jr.job.rid, ring,
+					result.ProjectedShells, medR, cfg.PlotDir, cfg.TextDir,
+				)
+			}
 		}
-	}
-	
+
+		key := checkpointKey{RID: int32(jr.job.rid), Seed: cfg.RotationSeed}
+		if err := appendCheckpoint(cfg.SaveDir, key); err != nil {
+			log.Fatal(err.Error())
+		}
+	})
+	fmt.Printf("Final cache hit rate: %.1f%%\n", cache.HitRate()*100)
+
 	plt.Execute()
 }
 
@@ -298,7 +209,7 @@ func subhaloSpheres(
 
 func plotExampleProfiles(
 	hp *los.HaloProfiles, m float64, ring int,
-	rs, rhos []float64, dir string,
+	rs, rhos []float64, dir string, rMaxMult float64, window int,
 ) {
 	fname := path.Join(dir, fmt.Sprintf("profs_h%d_r%d.png", hp.ID(), ring))
 
@@ -312,7 +223,7 @@ func plotExampleProfiles(
 	for cIdx, visIdx := range visProfs {
 		hp.GetRhos(ring, visIdx, rhos)
 		rhoSets, auxSets := analyze.NaNSplit(rhos, analyze.Aux(rs))
-		
+
 		for i := range rhoSets {
 			rawRs, rawRhos := auxSets[0][i], rhoSets[i]
 			smoothRhos, smoothDerivs, ok := analyze.Smooth(
@@ -350,7 +261,7 @@ func plotExampleProfiles(
 
 func plotExampleDerivs(
 	hp *los.HaloProfiles, m float64, ring int,
-	rs, rhos []float64, dir string,
+	rs, rhos []float64, dir string, rMaxMult float64, window int,
 ) {
 	fname := path.Join(dir, fmt.Sprintf("derivs_h%d_r%d.png", hp.ID(), ring))
 
@@ -440,7 +351,7 @@ func plotPlane(
 	plt.Figure(plt.Num(1), plt.FigSize(8, 8))
 	plt.InsertLine("plt.clf()")
 	plt.Plot(xs, ys, "ow")
-	
+
 	rf := kt.GetRFunc(4, analyze.Radial)
 	spXs, radXs := make([]float64, 200), make([]float64, 200)
 	spYs, radYs := make([]float64, 200), make([]float64, 200)
@@ -457,7 +368,7 @@ func plotPlane(
 	plt.Plot(spXs, spYs, plt.Color("r"), plt.LW(2))
 	plt.Plot(fXs, fYs, "o", plt.Color("r"))
 	plt.Plot(radXs, radYs, plt.Color("g"), plt.LW(2))
-	
+
 	for i, pShell := range pShells {
 		rXs, rYs := make([]float64, 100), make([]float64, 100)
 		for i := range rXs {
@@ -474,7 +385,7 @@ func plotPlane(
 	for i := 0; i < r.N; i++ {
 		if r.Oks[i] {
 			for visIdx, j := range visProfs {
-				if j == i { 
+				if j == i {
 					plt.Plot(
 						[]float64{r.PlaneXs[i]}, []float64{r.PlaneYs[i]},
 						"o", plt.Color(colors[visIdx % len(colors)]),
@@ -484,7 +395,7 @@ func plotPlane(
 		}
 	}
 
-	
+
 	plt.Title(fmt.Sprintf(`Halo %d: $M_{\rm 200m}$ = %.3g $M_\odot/h$`, id, m))
 	plt.XLabel(`$X_1$ $[{\rm Mpc}/h]$`, plt.FontSize(16))
 	plt.YLabel(`$X_2$ $[{\rm Mpc}/h]$`, plt.FontSize(16))
@@ -500,7 +411,7 @@ func plotPlane(
 
 func plotTracers(
 	hs []los.HaloProfiles, rbs [][]analyze.RingBuffer,
-	id, step, samples int, plotDir string,
+	id, step, samples, orderI, orderJ int, plotDir string,
 ) {
 	linName := path.Join(plotDir, fmt.Sprintf("trace_h%d_lin.png", id))
 	logName := path.Join(plotDir, fmt.Sprintf("trace_h%d_log.png", id))
@@ -513,10 +424,22 @@ func plotTracers(
 		h := &hs[ih]
 		xs, ys, _ := analyze.FilterPoints(rbs[ih], 4)
 		hRingCounts, hShells := analyze.CumulativeShells(
-			xs, ys, h, I, J, start, stop, step,
+			xs, ys, h, orderI, orderJ, start, stop, step,
 		)
 		ringCounts = hRingCounts
 		shells = append(shells, hShells)
+
+		// The primary halo's own cumulative-ring-count shells are also a
+		// realization ensemble; report their bootstrap/jackknife spread
+		// alongside the per-ring-count std/mean curves plotted below.
+		if ih == 0 {
+			ensemble := analyze.NewShellEnsemble(hShells, samples)
+			vol := ensemble.Volume()
+			fmt.Printf(
+				"Halo %d: Volume = %.4g +/- %.4g (jackknife) +/- %.4g (bootstrap)\n",
+				id, vol.Mean, vol.JackknifeErr, vol.BootstrapErr,
+			)
+		}
 	}
 
 	means, stds := analyze.CumulativeTracers(shells, samples)
@@ -580,7 +503,7 @@ func plotTracers(
 
 func setXRange(xLow, xHigh float64) {
 	if (xLow < 1 && xHigh  > 1) ||
-		(xLow < 0.1 && xHigh > 0.1) || 
+		(xLow < 0.1 && xHigh > 0.1) ||
 		(xLow < 0.01 && xHigh > 0.01) {
 		plt.XLim(xLow, xHigh)
 	}
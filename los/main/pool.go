@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/phil-mansfield/gotetra/los"
+	"github.com/phil-mansfield/gotetra/los/geom"
+)
+
+// haloJob is one unit of work for the worker pool: a catalog index plus
+// the inputs ProcessHalo needs to run it.
+type haloJob struct {
+	idx    int
+	rid    int
+	origin *geom.Vec
+	radius float64
+}
+
+// haloJobResult pairs a completed job's HaloResult with how long it took
+// to compute, for the pool's per-halo timing report.
+type haloJobResult struct {
+	job     haloJob
+	result  *HaloResult
+	err     error
+	elapsed time.Duration
+}
+
+// RunPool processes jobs across nWorkers goroutines, each with its own
+// los.Buffers backed by the shared cache, calling onResult for each
+// completed job as it streams back. Results are delivered to onResult in
+// completion order, not job order, since workers finish halos at
+// different rates; onResult runs on RunPool's own goroutine, so it's only
+// ever called for one result at a time and is the right place to do
+// per-halo work (writing output, appending a checkpoint record) that
+// should survive an interruption partway through the run, rather than
+// waiting for every job in the pool to finish first.
+func RunPool(
+	cfg *Config, ds *Dataset, cache *SheetCache, jobs []haloJob, nWorkers int,
+	onResult func(haloJobResult),
+) {
+	if nWorkers < 1 {
+		nWorkers = 1
+	}
+
+	jobCh := make(chan haloJob)
+	resCh := make(chan haloJobResult)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(nWorkers)
+	for w := 0; w < nWorkers; w++ {
+		go func() {
+			defer wg.Done()
+			buf := los.NewBuffers(
+				ds.Files[0], &ds.Hds[0], los.Cache(cache),
+				los.SubsampleLength(cfg.SubsampleLength),
+			)
+			for job := range jobCh {
+				start := time.Now()
+				result, err := ProcessHalo(
+					cfg, ds, buf, job.rid, job.origin, job.radius,
+				)
+				resCh <- haloJobResult{
+					job: job, result: result, err: err,
+					elapsed: time.Since(start),
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, j := range jobs {
+			jobCh <- j
+		}
+		close(jobCh)
+	}()
+	go func() {
+		wg.Wait()
+		close(resCh)
+	}()
+
+	for r := range resCh {
+		fmt.Printf(
+			"halo %d done in %s (cache hit rate so far: %.1f%%)\n",
+			r.job.rid, r.elapsed, cache.HitRate()*100,
+		)
+		onResult(r)
+	}
+}
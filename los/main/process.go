@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/phil-mansfield/gotetra/render/io"
+
+	"github.com/phil-mansfield/gotetra/los"
+	"github.com/phil-mansfield/gotetra/los/analyze"
+	"github.com/phil-mansfield/gotetra/los/geom"
+)
+
+// Dataset bundles the sheet headers and file names every halo in a run
+// reads density from, so ProcessHalo doesn't need to re-derive them on
+// every call. Each worker in the pool keeps its own los.Buffers (passed
+// separately to ProcessHalo) so that concurrent halos don't share
+// mutable scratch space; the Buffers still share decoded sheet data
+// through a SheetCache (see cache.go, pool.go).
+type Dataset struct {
+	Hds   []io.SheetHeader
+	Files []string
+}
+
+// HaloResult is everything ProcessHalo computes for one halo: the first
+// reference realization's fitted shell and its Penna coefficients, plus the
+// intermediate HaloProfiles/RingBuffers/ProjectedShells a caller needs in
+// order to plot the fit (see plotPlane in main.go).
+type HaloResult struct {
+	Shell  analyze.Shell
+	Coeffs []float64
+
+	// R200m, OrderI, and OrderJ record the inputs the fit above used, so
+	// a structured writer (see writeShellHDF5) doesn't have to go back to
+	// cfg/the Rockstar catalog to report them next to Coeffs.
+	R200m          float64
+	OrderI, OrderJ int
+
+	// FitXs/FitYs are the splashback points Coeffs was fit to: the first
+	// reference realization's points after the KDE-tree outlier filter
+	// (analyze.FilterPoints), rather than every ring's raw OkPlaneCoords
+	// point -- the latter still includes the points the fit itself
+	// rejected.
+	FitXs, FitYs []float64
+
+	Halo            *los.HaloProfiles
+	Rings           []analyze.RingBuffer
+	ProjectedShells []analyze.ProjectedShell
+	Ensemble        *analyze.ShellEnsemble
+}
+
+// ProcessHalo runs the full shell-fitting pipeline for a single halo: it
+// builds the primary and reference-orientation los.HaloProfiles
+// (cfg.RefRingCounts sets how many reference realizations to build and
+// how many rings each uses), loads their densities from ds, fits Penna
+// coefficients to each reference realization via PennaPlaneFit, and
+// returns the first realization's shell as the halo's result. This is
+// the same pipeline main() used to run inline; factoring it out here
+// lets it be driven from a batch scheduler, from tests, or from any
+// other CLI, not just this package's main().
+func ProcessHalo(
+	cfg *Config, ds *Dataset, buf *los.Buffers,
+	rid int, origin *geom.Vec, radius float64,
+) (*HaloResult, error) {
+	if len(cfg.RefRingCounts) == 0 {
+		return nil, fmt.Errorf(
+			"ProcessHalo: cfg.RefRingCounts must have at least one entry",
+		)
+	}
+
+	rSeed := rand.New(rand.NewSource(cfg.RotationSeed))
+
+	hs := make([]los.HaloProfiles, len(cfg.RefRingCounts)+1)
+	h := &hs[0]
+	hRefs := hs[1:]
+
+	h.Init(
+		rid, cfg.Rings, origin, radius*cfg.RMinMult, radius*cfg.RMaxMult,
+		cfg.RBins, cfg.Spokes, ds.Hds[0].TotalWidth, los.Log(cfg.LogProfile),
+	)
+
+	// A coarse, subsampled pass over just the sheets this halo's primary
+	// ring set intersects gives a rough map of where its mass actually
+	// lies, so the reference realizations below can be biased toward
+	// resolving that anisotropy instead of spending equal effort in
+	// every direction.
+	hdCoarse, fileCoarse := intersectingSheets(h, ds.Hds, ds.Files)
+	hist := buildCoarseHistogram(
+		hdCoarse, fileCoarse, origin, radius*cfg.RMinMult, radius*cfg.RMaxMult,
+		cfg.SubsampleLength, cfg.HistBinsTheta, cfg.HistBinsPhi,
+	)
+	norms, isoWeights := healpixSampler(len(hRefs))
+	sampler := los.NewImportanceSampler(
+		cfg.HistBinsTheta, cfg.HistBinsPhi, hist, norms, isoWeights,
+	)
+
+	axes, weights := importanceAxes(len(hRefs), rSeed.Float64, sampler)
+	for j := range hRefs {
+		roll := 2 * math.Pi * rSeed.Float64()
+		angleX, angleY, angleZ := eulerFromAxis(axes[j], roll)
+		hRefs[j].Init(
+			rid, cfg.RefRingCounts[j], origin,
+			radius*cfg.RMinMult, radius*cfg.RMaxMult,
+			cfg.RBins, cfg.Spokes, ds.Hds[0].TotalWidth,
+			los.Log(cfg.LogProfile),
+			los.Rotate(angleX, angleY, angleZ),
+		)
+	}
+
+	hdIntrs, fileIntrs := intersectingSheets(h, ds.Hds, ds.Files)
+	los.LoadDensities(hs, hdIntrs, fileIntrs, buf)
+
+	rbs := make([]analyze.RingBuffer, cfg.Rings)
+	for i := range rbs {
+		rbs[i].Init(cfg.Spokes, cfg.RBins)
+	}
+	for i := range rbs {
+		rbs[i].Splashback(h, i, cfg.Window, cfg.Cutoff)
+	}
+	var fitXs, fitYs []float64
+
+	rbRefs := make([][]analyze.RingBuffer, len(hRefs))
+	for j := range rbRefs {
+		rbRefs[j] = make([]analyze.RingBuffer, cfg.RefRingCounts[j])
+		for i := range rbRefs[j] {
+			rbRefs[j][i].Init(cfg.Spokes, cfg.RBins)
+		}
+		for i := range rbRefs[j] {
+			rbRefs[j][i].Splashback(&hRefs[j], i, cfg.Window, cfg.Cutoff)
+		}
+	}
+
+	pShells := make([]analyze.ProjectedShell, len(hRefs))
+	shells := make([]analyze.Shell, len(hRefs))
+	var primaryShell analyze.Shell
+	var primaryCoeffs []float64
+	for j := range hRefs {
+		pxs, pys, _ := analyze.FilterPoints(rbRefs[j], cfg.KDELevels)
+		cs, pShell := analyze.PennaPlaneFit(
+			pxs, pys, &hRefs[j], cfg.OrderI, cfg.OrderJ,
+		)
+		shell := analyze.PennaFunc(cs, cfg.OrderI, cfg.OrderJ, 2)
+
+		v := shell.Volume(100 * 1000)
+		vRad := math.Pow(v/(4*math.Pi/3), 0.3333)
+		PrintCoeffs(cs, vRad, radius)
+		printShellStats(shell, rid, j, 10*1000)
+
+		pShells[j] = pShell
+		shells[j] = shell
+		if j == 0 {
+			primaryShell, primaryCoeffs = shell, cs
+			fitXs, fitYs = pxs, pys
+		}
+	}
+
+	// The reference realizations are importance-sampled orientations of
+	// the same halo (see importanceAxes), so their spread in
+	// Volume/SurfaceArea/etc. gives a bootstrap/jackknife uncertainty on
+	// each quantity, on top of the single-realization value reported
+	// above. The ensemble is weighted by each realization's importance
+	// weight so the average still converges to the isotropic mean.
+	ensemble := analyze.NewWeightedShellEnsemble(shells, weights, 10*1000)
+
+	result := &HaloResult{
+		Shell:           primaryShell,
+		Coeffs:          primaryCoeffs,
+		R200m:           radius,
+		OrderI:          cfg.OrderI,
+		OrderJ:          cfg.OrderJ,
+		FitXs:           fitXs,
+		FitYs:           fitYs,
+		Halo:            h,
+		Rings:           rbs,
+		ProjectedShells: pShells,
+		Ensemble:        ensemble,
+	}
+
+	if err := writeShellOutput(cfg, rid, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+
+	"github.com/phil-mansfield/gotetra/los"
+	"github.com/phil-mansfield/gotetra/los/geom"
+	"github.com/phil-mansfield/gotetra/los/sphere_halo"
+)
+
+// healpixSampler builds the isotropic HEALPix ring-normal lattice (see
+// los/sphere_halo/norms.go's HealpixNorms, built for chunk0-3's ring
+// deposition) importanceAxes draws n reference-realization axes from,
+// sized so the lattice has at least n pixels to choose among.
+func healpixSampler(n int) (norms []geom.Vec, weights []float64) {
+	nside := 1
+	for 6*nside*nside < n {
+		nside++
+	}
+	w := sphere_halo.HealpixNorms(nside)
+	return w.Norms, w.Weights
+}
+
+// importanceAxes draws n unit-vector rotation axes from sampler, together
+// with each draw's importance weight, for use as reference-realization
+// orientations (see analyze.NewWeightedShellEnsemble).
+//
+// This replaces drawing a reference realization's whole-halo rotation
+// from three independent Uniform(0, 2*pi) Euler angles: composing three
+// such angles does not sample SO(3) isotropically, since the resulting
+// pole is over-represented relative to the equator. sampler draws the
+// pole instead -- either uniformly from an isotropic lattice
+// (los.NewUniformSampler) or biased toward the halo's own mass
+// concentration (los.NewImportanceSampler, fed by a coarse density pass;
+// see los/main's buildCoarseHistogram) -- and returns each draw's
+// importance weight so a weighted ensemble average still converges to
+// the isotropic mean regardless of which sampler drew it.
+//
+// uniform01 is called by sampler (once or more per axis, depending on
+// its own implementation) so that both math/rand.Generator- and stdlib
+// math/rand-based callers can share it.
+func importanceAxes(
+	n int, uniform01 func() float64, sampler los.OrientationSampler,
+) ([]geom.Vec, []float64) {
+	axes := make([]geom.Vec, n)
+	weights := make([]float64, n)
+	for i := range axes {
+		axes[i], weights[i] = sampler.Sample(uniform01)
+	}
+	return axes, weights
+}
+
+// eulerFromAxis converts a rotation axis direction and a free roll angle
+// about it into the (angleX, angleY, angleZ) triple los.Rotate expects.
+func eulerFromAxis(axis geom.Vec, roll float64) (x, y, z float32) {
+	theta := math.Acos(float64(axis[2]))
+	phi := math.Atan2(float64(axis[1]), float64(axis[0]))
+	return float32(phi), float32(theta), float32(roll)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	hdf5 "github.com/sbinet/go-hdf5"
+
+	"github.com/phil-mansfield/gotetra/los/analyze"
+)
+
+// shellHDF5Mu serializes every writeShellHDF5 call across a run: each
+// call opens, appends a group to, and closes the same shells.hdf5 file,
+// which races when RunPool's workers reach writeShellOutput concurrently
+// (cfg.Workers > 1). The file itself, not any one halo's data, is the
+// shared resource, so a single package-level mutex is enough -- two
+// different halos' groups never touch the same bytes, but go-hdf5's
+// File handle isn't safe for concurrent use regardless.
+var shellHDF5Mu sync.Mutex
+
+// writeShellOutput records one halo's Penna coefficients, per-ring
+// splashback points, and ensemble stats, according to cfg.Output. This
+// is main()'s structured counterpart to gtet_shell.go's
+// printOrWriteRows/writeHDF5Rows (see gtet_hdf5.go).
+func writeShellOutput(cfg *Config, rid int, result *HaloResult) error {
+	switch cfg.Output {
+	case "hdf5":
+		return writeShellHDF5(cfg, rid, result)
+	case "text", "":
+		return writeEnsembleStats(result.Ensemble, rid, cfg.TextDir)
+	default:
+		return fmt.Errorf("unrecognized Output value %q", cfg.Output)
+	}
+}
+
+// writeShellHDF5 appends one halo's Penna coefficients (and the fit's
+// order/R200m/KDE-level inputs), its KDE-filtered splashback fit points,
+// and its ensemble stats to cfg.SaveDir/shells.hdf5, one top-level group
+// per halo, so ecosystem tooling (h5py, etc.) can load the whole shell
+// catalog directly instead of parsing the plotPlane plots and
+// ensemble_stats.txt by hand.
+func writeShellHDF5(cfg *Config, rid int, result *HaloResult) error {
+	shellHDF5Mu.Lock()
+	defer shellHDF5Mu.Unlock()
+
+	fName := path.Join(cfg.SaveDir, "shells.hdf5")
+
+	var f *hdf5.File
+	var err error
+	if _, statErr := os.Stat(fName); statErr == nil {
+		f, err = hdf5.OpenFile(fName, hdf5.F_ACC_RDWR)
+	} else {
+		f, err = hdf5.CreateFile(fName, hdf5.F_ACC_TRUNC)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	group, err := f.CreateGroup(fmt.Sprintf("halo_%d", rid))
+	if err != nil {
+		return err
+	}
+
+	if err := group.WriteDataset("coeffs", result.Coeffs); err != nil {
+		return err
+	}
+	if err := group.WriteDataset(
+		"penna_order", []int64{int64(result.OrderI), int64(result.OrderJ)},
+	); err != nil {
+		return err
+	}
+	if err := group.WriteDataset("r200m", []float64{result.R200m}); err != nil {
+		return err
+	}
+	if err := group.WriteDataset(
+		"kde_levels", []int64{int64(cfg.KDELevels)},
+	); err != nil {
+		return err
+	}
+
+	// fit_xs/fit_ys are the KDE-filtered splashback points Coeffs was fit
+	// to (see HaloResult.FitXs), not every ring's raw OkPlaneCoords
+	// point -- the latter still includes points the fit itself threw out
+	// as outliers.
+	if err := group.WriteDataset("fit_xs", result.FitXs); err != nil {
+		return err
+	}
+	if err := group.WriteDataset("fit_ys", result.FitYs); err != nil {
+		return err
+	}
+
+	if result.Ensemble != nil {
+		rsp := result.Ensemble.Rsp()
+		if err := group.WriteDataset(
+			"rsp", []float64{rsp.Mean, rsp.JackknifeErr, rsp.BootstrapErr},
+		); err != nil {
+			return err
+		}
+		if err := writeEnsembleHDF5(group, result.Ensemble); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeEnsembleHDF5 writes a halo's ShellEnsemble rows as parallel
+// "names"/"mean"/"jackknife_err"/"bootstrap_err" datasets in a new
+// "ensemble_stats" subgroup of group.
+func writeEnsembleHDF5(group *hdf5.Group, e *analyze.ShellEnsemble) error {
+	rows := ensembleStatRows(e)
+
+	names := make([]string, len(rows))
+	means := make([]float64, len(rows))
+	jackknife := make([]float64, len(rows))
+	bootstrap := make([]float64, len(rows))
+	for i, row := range rows {
+		names[i] = row.name
+		means[i] = row.stat.Mean
+		jackknife[i] = row.stat.JackknifeErr
+		bootstrap[i] = row.stat.BootstrapErr
+	}
+
+	statGroup, err := group.CreateGroup("ensemble_stats")
+	if err != nil {
+		return err
+	}
+	if err := statGroup.WriteDataset("names", names); err != nil {
+		return err
+	}
+	if err := statGroup.WriteDataset("mean", means); err != nil {
+		return err
+	}
+	if err := statGroup.WriteDataset("jackknife_err", jackknife); err != nil {
+		return err
+	}
+	return statGroup.WriteDataset("bootstrap_err", bootstrap)
+}
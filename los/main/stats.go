@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/phil-mansfield/gotetra/los/analyze"
+)
+
+// ensembleStatRow names one row of writeEnsembleStats's table.
+type ensembleStatRow struct {
+	name string
+	stat analyze.Stat
+}
+
+// ensembleStatRows flattens a ShellEnsemble's Volume, SurfaceArea,
+// Moments, Rsp, MeanRadius, MedianRadius, and RadialRange stats into a
+// tidy list of named rows.
+func ensembleStatRows(e *analyze.ShellEnsemble) []ensembleStatRow {
+	ix, iy, iz := e.Moments()
+	rMin, rMax := e.RadialRange()
+	return []ensembleStatRow{
+		{"Volume", e.Volume()},
+		{"SurfaceArea", e.SurfaceArea()},
+		{"Ix", ix},
+		{"Iy", iy},
+		{"Iz", iz},
+		{"Rsp", e.Rsp()},
+		{"MeanRadius", e.MeanRadius()},
+		{"MedianRadius", e.MedianRadius()},
+		{"RMin", rMin},
+		{"RMax", rMax},
+	}
+}
+
+// writeEnsembleStats prints a tidy table of e's bootstrap/jackknife
+// uncertainties for halo rid, and appends the same rows to
+// textDir/ensemble_stats.txt so a run's full catalog of uncertainties
+// can be loaded back out after the fact.
+func writeEnsembleStats(e *analyze.ShellEnsemble, rid int, textDir string) error {
+	rows := ensembleStatRows(e)
+
+	fmt.Printf("Halo %d ensemble stats (%d realizations):\n", rid, e.Len())
+	fmt.Printf("  %-12s %12s %12s %12s\n",
+		"quantity", "mean", "jackknife", "bootstrap")
+	for _, row := range rows {
+		fmt.Printf("  %-12s %12.5g %12.5g %12.5g\n",
+			row.name, row.stat.Mean, row.stat.JackknifeErr, row.stat.BootstrapErr)
+	}
+
+	if textDir == "" {
+		return nil
+	}
+
+	fName := path.Join(textDir, "ensemble_stats.txt")
+	f, err := os.OpenFile(fName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, row := range rows {
+		_, err := fmt.Fprintf(f, "%d %s %.6g %.6g %.6g\n",
+			rid, row.name, row.stat.Mean, row.stat.JackknifeErr, row.stat.BootstrapErr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package los
+
+import (
+	"math"
+	"sort"
+
+	"github.com/phil-mansfield/gotetra/los/geom"
+)
+
+// OrientationSampler draws a reference realization's rotation axis,
+// together with the importance weight a caller should give that draw
+// when averaging over many of them (see analyze.NewWeightedShellEnsemble).
+// uniform01 supplies whatever independent Uniform(0, 1) draws the
+// sampler needs.
+type OrientationSampler interface {
+	Sample(uniform01 func() float64) (axis geom.Vec, weight float64)
+}
+
+// UniformSampler draws axes uniformly from a fixed isotropic lattice
+// (e.g. sphere_halo.HealpixNorms), returning each pixel's own solid-angle
+// weight unchanged -- every draw is already isotropic, so no importance
+// correction is needed.
+type UniformSampler struct {
+	norms   []geom.Vec
+	weights []float64
+}
+
+// NewUniformSampler wraps an isotropic lattice's norms/weights (as
+// returned by sphere_halo.HealpixNorms) for flat, unweighted sampling.
+func NewUniformSampler(norms []geom.Vec, weights []float64) *UniformSampler {
+	return &UniformSampler{norms: norms, weights: weights}
+}
+
+func (s *UniformSampler) Sample(uniform01 func() float64) (geom.Vec, float64) {
+	j := int(uniform01() * float64(len(s.norms)))
+	if j >= len(s.norms) {
+		j = len(s.norms) - 1
+	}
+	return s.norms[j], s.weights[j]
+}
+
+// ImportanceSampler draws axes from the same isotropic lattice a
+// UniformSampler would use, but weighted toward directions where a
+// coarse histBinsTheta x histBinsPhi histogram of the halo's own mass
+// (built from a preliminary low-resolution pass over its particles --
+// see los/main's buildCoarseHistogram) is concentrated, so that a fixed
+// reference-realization budget resolves the halo's actual anisotropy
+// instead of spending equal effort on directions that turn out to be
+// nearly spherical.
+//
+// Every draw's returned weight is the standard importance-sampling
+// correction, target density over sampling density: the lattice pixel's
+// own isotropic solid-angle weight divided by the probability
+// ImportanceSampler actually assigned it. A weighted average over many
+// draws (analyze.NewWeightedShellEnsemble) therefore still converges to
+// the isotropic mean even though individual directions were drawn more
+// or less often than chance.
+type ImportanceSampler struct {
+	histBinsTheta, histBinsPhi int
+
+	norms   []geom.Vec
+	cum     []float64 // cumulative sampling probability, norms[i]'s slot ends at cum[i]
+	iWeight []float64 // precomputed per-norm importance weight
+}
+
+// NewImportanceSampler builds an ImportanceSampler over norms/isoWeights
+// (an isotropic lattice and its solid-angle weights), biasing the draw
+// toward whichever of hist's histBinsTheta x histBinsPhi (polar angle x
+// azimuth) bins contain the most mass. hist[ti][pi] need only be
+// proportional to the mass in that bin; NewImportanceSampler normalizes
+// it itself. A small floor is mixed into every bin's probability so that
+// empty bins (and the tails of a very concentrated histogram) are still
+// reachable, just rarely.
+func NewImportanceSampler(
+	histBinsTheta, histBinsPhi int, hist [][]float64,
+	norms []geom.Vec, isoWeights []float64,
+) *ImportanceSampler {
+	const floor = 0.1 // fraction of sampling probability spent uniformly
+
+	isoSum := 0.0
+	for _, w := range isoWeights {
+		isoSum += w
+	}
+
+	binProb := make([]float64, len(norms))
+	sum := 0.0
+	for i, n := range norms {
+		ti, pi := HistBin(n, histBinsTheta, histBinsPhi)
+		binProb[i] = hist[ti][pi]
+		sum += binProb[i]
+	}
+
+	iWeight := make([]float64, len(norms))
+	cum := make([]float64, len(norms))
+	running := 0.0
+	for i := range norms {
+		uniform := 1 / float64(len(norms))
+		var biased float64
+		if sum > 0 {
+			biased = binProb[i] / sum
+		} else {
+			biased = uniform
+		}
+		p := floor*uniform + (1-floor)*biased
+		running += p
+		cum[i] = running
+
+		target := isoWeights[i] / isoSum
+		iWeight[i] = target / p
+	}
+
+	return &ImportanceSampler{
+		histBinsTheta: histBinsTheta, histBinsPhi: histBinsPhi,
+		norms: norms, cum: cum, iWeight: iWeight,
+	}
+}
+
+func (s *ImportanceSampler) Sample(uniform01 func() float64) (geom.Vec, float64) {
+	u := uniform01() * s.cum[len(s.cum)-1]
+	i := sort.SearchFloat64s(s.cum, u)
+	if i >= len(s.cum) {
+		i = len(s.cum) - 1
+	}
+	return s.norms[i], s.iWeight[i]
+}
+
+// HistBin returns the (theta, phi) histogram cell a unit vector falls
+// in, for a histBinsTheta x histBinsPhi grid over theta in [0, pi] and
+// phi in [-pi, pi]. Callers building a histogram for NewImportanceSampler
+// (e.g. los/main's buildCoarseHistogram) must bin their mass with this
+// same function so the two agree on what each cell means.
+func HistBin(v geom.Vec, histBinsTheta, histBinsPhi int) (ti, pi int) {
+	theta := math.Acos(clamp(float64(v[2]), -1, 1))
+	phi := math.Atan2(float64(v[1]), float64(v[0]))
+
+	ti = int(theta / math.Pi * float64(histBinsTheta))
+	pi = int((phi + math.Pi) / (2 * math.Pi) * float64(histBinsPhi))
+	if ti >= histBinsTheta {
+		ti = histBinsTheta - 1
+	}
+	if pi >= histBinsPhi {
+		pi = histBinsPhi - 1
+	}
+	return ti, pi
+}
+
+func clamp(x, lo, hi float64) float64 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
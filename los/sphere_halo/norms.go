@@ -0,0 +1,172 @@
+package sphere_halo
+
+import (
+	"math"
+
+	"github.com/phil-mansfield/gotetra/los/geom"
+)
+
+// Weighted bundles a set of ring normals together with the solid-angle
+// weight each one's great circle should carry in a ring-averaged profile.
+// len(Norms) == len(Weights).
+type Weighted struct {
+	Norms   []geom.Vec
+	Weights []float64
+}
+
+// TetrahedralNorms returns the four face normals of a regular tetrahedron,
+// each weighted equally.
+func TetrahedralNorms() Weighted {
+	norms := []geom.Vec{
+		unit(geom.Vec{1, 1, 1}),
+		unit(geom.Vec{1, -1, -1}),
+		unit(geom.Vec{-1, 1, -1}),
+		unit(geom.Vec{-1, -1, 1}),
+	}
+	return uniformWeighted(norms)
+}
+
+// OctahedralNorms returns the four independent face normals of a regular
+// octahedron. (The other four are antipodes of these, and a ring's great
+// circle already integrates over both hemispheres.)
+func OctahedralNorms() Weighted {
+	norms := []geom.Vec{
+		unit(geom.Vec{1, 1, 1}),
+		unit(geom.Vec{1, 1, -1}),
+		unit(geom.Vec{1, -1, 1}),
+		unit(geom.Vec{1, -1, -1}),
+	}
+	return uniformWeighted(norms)
+}
+
+// IcosahedralNorms returns the ten independent face normals of a regular
+// icosahedron.
+func IcosahedralNorms() Weighted {
+	// The 20 face normals of a regular icosahedron are the unit vectors to
+	// the vertices of its dual, a regular dodecahedron, which come in 10
+	// antipodal pairs: four representatives from the {±1,±1,±1}
+	// cube-vertex family and two from each of the three {0,±1/φ,±φ}
+	// axis-permutation families.
+	// This is the same dedup pattern dodecahedronNorms uses, since both
+	// generators are picking 10 independent directions out of the same
+	// 20-vertex dodecahedron.
+	return uniformWeighted(dodecahedronNorms())
+}
+
+// Dodecahedral120CellNorms returns the 6 independent face normals of a
+// regular dodecahedron together with the 10 of its dual icosahedron,
+// combined into a single 16-direction sampling of the sphere (the
+// "120-cell" ring set).
+func Dodecahedral120CellNorms() Weighted {
+	phi := (1 + math.Sqrt(5)) / 2
+	// A regular dodecahedron's 12 face normals point at the vertices of its
+	// dual icosahedron, {0,±1,±φ} and its cyclic permutations, which come in
+	// 6 antipodal pairs.
+	dodec := []geom.Vec{
+		unit(geom.Vec{0, 1, float32(phi)}), unit(geom.Vec{0, 1, float32(-phi)}),
+		unit(geom.Vec{1, float32(phi), 0}), unit(geom.Vec{-1, float32(phi), 0}),
+		unit(geom.Vec{float32(phi), 0, 1}), unit(geom.Vec{float32(-phi), 0, 1}),
+	}
+	icosa := IcosahedralNorms()
+
+	norms := make([]geom.Vec, 0, len(dodec)+len(icosa.Norms))
+	norms = append(norms, dodec...)
+	norms = append(norms, icosa.Norms...)
+	return uniformWeighted(norms)
+}
+
+func dodecahedronNorms() []geom.Vec {
+	phi := (1 + math.Sqrt(5)) / 2
+
+	raw := []geom.Vec{
+		{1, 1, 1}, {1, 1, -1}, {1, -1, 1}, {1, -1, -1},
+		{0, float32(1 / phi), float32(phi)}, {0, float32(1 / phi), float32(-phi)},
+		{float32(1 / phi), float32(phi), 0}, {float32(-1 / phi), float32(phi), 0},
+		{float32(phi), 0, float32(1 / phi)}, {float32(phi), 0, float32(-1 / phi)},
+	}
+	norms := make([]geom.Vec, len(raw))
+	for i, v := range raw {
+		norms[i] = unit(v)
+	}
+	return norms
+}
+
+// HealpixNorms returns the ring normals implied by the centers of the
+// hemisphere of HEALPix pixels at the given nside resolution. Because each
+// ring normal's great circle already integrates both the pixel and its
+// antipode, only the northern-hemisphere pixel centers are returned.
+// The returned weights are each pixel's solid angle, 4*pi/(12*nside^2).
+func HealpixNorms(nside int) Weighted {
+	npix := 12 * nside * nside
+	pixArea := 4 * math.Pi / float64(npix)
+
+	norms := make([]geom.Vec, 0, npix/2)
+	weights := make([]float64, 0, npix/2)
+
+	for p := 0; p < npix; p++ {
+		z, phi := healpixPixelCenter(p, nside)
+		if z < 0 {
+			// Skip the southern hemisphere: its pixels are antipodes of
+			// the northern ones and the ring already covers both.
+			continue
+		}
+		sinTh := math.Sqrt(1 - z*z)
+		sinPhi, cosPhi := math.Sincos(phi)
+		norms = append(norms, geom.Vec{
+			float32(sinTh * cosPhi), float32(sinTh * sinPhi), float32(z),
+		})
+		weights = append(weights, pixArea)
+	}
+
+	return Weighted{norms, weights}
+}
+
+// healpixPixelCenter returns the (z = cos(theta), phi) center of pixel p in
+// the ring scheme at resolution nside, per Gorski et al. (2005).
+func healpixPixelCenter(p, nside int) (z, phi float64) {
+	npix := 12 * nside * nside
+	ncap := 2 * nside * (nside - 1)
+
+	switch {
+	case p < ncap:
+		// North polar cap.
+		i := int((1 + math.Sqrt(float64(1+2*p))) / 2)
+		j := p - 2*i*(i-1)
+		z = 1 - float64(i*i)/(3*float64(nside)*float64(nside))
+		phi = (float64(j) + 0.5) * math.Pi / (2 * float64(i))
+	case p < npix-ncap:
+		// Equatorial belt.
+		pp := p - ncap
+		i := pp/(4*nside) + nside
+		j := pp%(4*nside) + 1
+		s := (i - nside + 1) % 2
+		z = float64(2*nside-i) * 2 / (3 * float64(nside))
+		phi = (float64(j) - float64(s)/2.0) * math.Pi / (2 * float64(nside))
+	default:
+		// South polar cap: mirror the north cap.
+		pp := npix - p - 1
+		i := int((1 + math.Sqrt(float64(1+2*pp))) / 2)
+		j := pp - 2*i*(i-1)
+		z = -(1 - float64(i*i)/(3*float64(nside)*float64(nside)))
+		phi = (float64(j) + 0.5) * math.Pi / (2 * float64(i))
+	}
+	return z, phi
+}
+
+func uniformWeighted(norms []geom.Vec) Weighted {
+	weights := make([]float64, len(norms))
+	w := 4 * math.Pi / float64(len(norms)) / 2
+	for i := range weights {
+		weights[i] = w
+	}
+	return Weighted{norms, weights}
+}
+
+func unit(v geom.Vec) geom.Vec {
+	norm := math.Sqrt(float64(v[0]*v[0] + v[1]*v[1] + v[2]*v[2]))
+	return geom.Vec{
+		float32(float64(v[0]) / norm),
+		float32(float64(v[1]) / norm),
+		float32(float64(v[2]) / norm),
+	}
+}
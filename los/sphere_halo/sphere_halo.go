@@ -39,6 +39,7 @@ import (
 	"github.com/phil-mansfield/gotetra/math/mat"
 	rgeom "github.com/phil-mansfield/gotetra/render/geom"
 	"github.com/phil-mansfield/gotetra/los/geom"
+	"github.com/phil-mansfield/gotetra/math/ops"
 )
 
 // Type SphereHalo represents a halo which can have spheres inserted into it.
@@ -53,21 +54,80 @@ type SphereHalo struct {
 
 	rots []mat.Matrix32
 	norms []geom.Vec
+	ringWeights []float64
+	order DepositionOrder
 	profs []los.ProfileRing
 }
 
+// DepositionOrder selects how a line of sight spreads the mass of an
+// inserted sphere across its neighboring radial bins.
+type DepositionOrder int
+
+const (
+	// Constant deposits a flat rho between rLo and rHi, the original
+	// donor-cell behavior. It produces staircasing at bin boundaries when
+	// many small spheres are stacked.
+	Constant DepositionOrder = iota
+	// Linear deposits a chord-length-weighted linear ramp between
+	// neighboring radial bins, giving second-order accuracy.
+	Linear
+	// Quadratic uses the two neighboring bins on either side for
+	// third-order accuracy.
+	Quadratic
+	// MonotonicLimited applies a van-Leer style limiter that reverts
+	// toward Constant near steep gradients, to prevent negative densities.
+	MonotonicLimited
+)
+
+// Option configures optional behavior passed to Init or InitWeighted.
+type Option func(h *SphereHalo)
+
+// Order selects the per-line-of-sight deposition scheme a halo's rings use.
+// Every scheme preserves the total inserted mass per line of sight exactly,
+// regardless of order.
+func Order(order DepositionOrder) Option {
+	return func(h *SphereHalo) { h.order = order }
+}
+
 // Init initializes a halo centered at origin with minimum and maximum radii
 // given by rMin, and rMax. It will consist of a family of rings whose normals
 // are given by the slice of vectors, norms. Each ring will consists of n
 // lines of sight and will have bins radial bins.
+//
+// By default, each line of sight deposits mass with the donor-cell Constant
+// scheme; pass Order(o) to opts to select a higher-order scheme instead.
+//
+// Rings are weighted uniformly. Callers that construct norms from one of the
+// generators in norms.go (TetrahedralNorms, HealpixNorms, etc.) and need the
+// solid-angle weights those generators compute should call InitWeighted
+// instead.
 func (h *SphereHalo) Init(
 	norms []geom.Vec, origin [3]float64,
-	rMin, rMax float64, bins, n int,
+	rMin, rMax float64, bins, n int, opts ...Option,
 ) {
+	h.InitWeighted(uniformWeighted(norms), origin, rMin, rMax, bins, n, opts...)
+}
+
+// InitWeighted is identical to Init, except that it additionally takes the
+// per-ring solid-angle weights associated with w.Norms, as returned by the
+// ring-normal generators in norms.go. RingWeight exposes these so that
+// downstream ring-averaging can weight each ring by the solid angle its
+// great circle actually represents instead of assuming every ring
+// contributes equally.
+func (h *SphereHalo) InitWeighted(
+	w Weighted, origin [3]float64,
+	rMin, rMax float64, bins, n int, opts ...Option,
+) {
+	norms := w.Norms
 	h.origin = origin
 	h.rMin, h.rMax = rMin, rMax
 	h.rings, h.bins, h.n = len(norms), bins, n
 	h.norms = norms
+	h.ringWeights = w.Weights
+	h.order = Constant
+	for _, opt := range opts {
+		opt(h)
+	}
 
 	zAxis := &geom.Vec{0, 0, 1}
 
@@ -75,7 +135,10 @@ func (h *SphereHalo) Init(
 	h.rots = make([]mat.Matrix32, h.rings)
 
 	for i := range h.profs {
-		h.profs[i].Init(math.Log(h.rMin), math.Log(h.rMax), h.bins, h.n)
+		h.profs[i].Init(
+			math.Log(h.rMin), math.Log(h.rMax), h.bins, h.n,
+			los.Order(los.DepositionOrder(h.order)),
+		)
 		h.rots[i].Init(make([]float32, 9), 3, 3)
 		geom.EulerMatrixBetweenAt(&norms[i], zAxis, &h.rots[i])
 	}
@@ -84,11 +147,18 @@ func (h *SphereHalo) Init(
 	h.ringVecs = make([][2]float64, h.n)
 	for i := 0; i < h.n; i++ {
 		h.ringPhis[i] = float64(i) / float64(n) * (2 * math.Pi)
-		h.ringVecs[i][1], h.ringVecs[i][0] = math.Sincos(h.ringPhis[i])
+		h.ringVecs[i][1], h.ringVecs[i][0] = ops.Sincos(h.ringPhis[i])
 	}
 	h.dPhi = 1 / float64(n) * (2 * math.Pi)
 }
 
+// RingWeight returns the solid-angle weight that the given ring's great
+// circle should carry in a ring-averaged profile. Rings built from Init (or
+// from a generator with no weight information) are all weighted equally.
+func (h *SphereHalo) RingWeight(ring int) float64 {
+	return h.ringWeights[ring]
+}
+
 // Split splits the halo h into copies and stores those copies in hs. The
 // total mass stored in h and all those copies is equal to the total mass
 // stored in h.
@@ -98,9 +168,14 @@ func (h *SphereHalo) Split(hs []SphereHalo) {
 	for i := range hs {
 		hi := &hs[i]
 		if h.rings != hi.rings || h.bins != hi.bins || h.n != hi.n {
-			hi.Init(h.norms, h.origin, h.rMin, h.rMax, h.bins, h.n)
+			hi.InitWeighted(
+				Weighted{h.norms, h.ringWeights},
+				h.origin, h.rMin, h.rMax, h.bins, h.n, Order(h.order),
+			)
 		} else {
 			hi.norms = h.norms
+			hi.ringWeights = h.ringWeights
+			hi.order = h.order
 			hi.rots = h.rots
 			hi.origin = h.origin
 			hi.rMin, hi.rMax = h.rMin, h.rMax
@@ -228,7 +303,7 @@ func (h *SphereHalo) insertToRing(vec geom.Vec, radius, rho float64, ring int) {
 	} else {
 		// Circle does not contain center.
 		alpha := halfAngularWidth(projDist2, projRad2)
-		projPhi := math.Atan2(cy, cx)
+		projPhi := ops.Atan2(cy, cx)
 		phiStart, phiEnd := projPhi-alpha, projPhi+alpha
 		iLo1, iHi1, iLo2, iHi2 := h.idxRange(phiStart, phiEnd)
 
@@ -284,7 +359,7 @@ func (h *SphereHalo) idxRange(
 // squared distance of dist2 and a squared radius of r2. It's assumed that
 // the circle does not contain the origin.
 func halfAngularWidth(dist2, r2 float64) float64 {
-	return math.Asin(math.Sqrt(r2/dist2))
+	return ops.Asin(ops.Sqrt(r2/dist2))
 }
 
 
@@ -294,8 +369,8 @@ func halfAngularWidth(dist2, r2 float64) float64 {
 // squared radius of the circle, and b is the impact parameter of the
 // ray and the center of the circle.
 func twoValIntrDist(dist2, rad2, b float64) (lo, hi float64) {
-	midDist := math.Sqrt(dist2 - rad2)
-	diff := math.Sqrt(rad2 - b*b)
+	midDist := ops.Sqrt(dist2 - rad2)
+	diff := ops.Sqrt(rad2 - b*b)
 	return midDist-diff, midDist+diff
 }
 
@@ -305,5 +380,5 @@ func twoValIntrDist(dist2, rad2, b float64) (lo, hi float64) {
 // squared radius of the circle, and b is the impact parameter of the
 // ray and the center of the circle.
 func oneValIntrDist(dist2, rad2, b float64) float64 {
-	return math.Sqrt(rad2 - dist2) + math.Sqrt(rad2 - b*b)
+	return ops.Sqrt(rad2 - dist2) + ops.Sqrt(rad2 - b*b)
 }
@@ -0,0 +1,253 @@
+// Package interpolate provides local interpolators over the uniform
+// position grids render/io reads out of sheet files: trilinear, tricubic
+// (Lagrange), and tricubic Hermite, all sharing the TriInterpolator
+// interface so gtet_shell's profile() can swap between them via a single
+// constructor closure.
+package interpolate
+
+import "math"
+
+// TriInterpolator evaluates an interpolated scalar field at an arbitrary
+// point inside the uniform grid it was built from.
+type TriInterpolator interface {
+	Eval(x, y, z float64) float64
+}
+
+// Cloner is implemented by TriInterpolators that carry mutable per-call
+// state (see triCubicHermite's corner cache below), so that a caller
+// fanning Eval calls out across multiple goroutines (e.g. gtet_shell's
+// interpolatorBinParticles) can give each one its own private copy
+// instead of racing on shared state. triLinear and triCubic hold no such
+// state and are already safe to call concurrently, so they don't
+// implement it.
+type Cloner interface {
+	Clone() TriInterpolator
+}
+
+// axis describes one uniformly-spaced coordinate axis of a sampled grid:
+// n samples, spaced dx apart, starting at x0.
+type axis struct {
+	x0, dx float64
+	n      int
+}
+
+// cell returns the index of the grid node at or below x, and x's fractional
+// offset past that node, in units of dx.
+func (a axis) cell(x float64) (i0 int, t float64) {
+	u := (x - a.x0) / a.dx
+	i0 = int(math.Floor(u))
+	return i0, u - float64(i0)
+}
+
+// clamp folds an out-of-range node index back to the nearest edge node,
+// so interpolators extrapolate flatly rather than reading out of bounds
+// past the grid's edge.
+func (a axis) clamp(i int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i > a.n-1:
+		return a.n - 1
+	default:
+		return i
+	}
+}
+
+// grid is the uniform x-fastest sample grid shared by every interpolator
+// in this file: vals[ix + iy*x.n + iz*x.n*y.n].
+type grid struct {
+	x, y, z axis
+	vals    []float64
+}
+
+func newGrid(
+	x0, dx float64, nx int, y0, dy float64, ny int, z0, dz float64, nz int,
+	vals []float64,
+) grid {
+	return grid{axis{x0, dx, nx}, axis{y0, dy, ny}, axis{z0, dz, nz}, vals}
+}
+
+func (g *grid) at(ix, iy, iz int) float64 {
+	ix, iy, iz = g.x.clamp(ix), g.y.clamp(iy), g.z.clamp(iz)
+	return g.vals[ix+iy*g.x.n+iz*g.x.n*g.y.n]
+}
+
+// triLinear interpolates by blending the 8 grid corners surrounding the
+// query point, the same CIC-style weighting density.depositCIC uses to
+// deposit mass.
+type triLinear struct{ grid }
+
+// NewUniformTriLinear builds a TriInterpolator over a uniform nx x ny x nz
+// grid of vals, linearly interpolated between neighboring corners.
+func NewUniformTriLinear(
+	x0, dx float64, nx int, y0, dy float64, ny int, z0, dz float64, nz int,
+	vals []float64,
+) TriInterpolator {
+	return &triLinear{newGrid(x0, dx, nx, y0, dy, ny, z0, dz, nz, vals)}
+}
+
+func (t *triLinear) Eval(x, y, z float64) float64 {
+	ix, tx := t.x.cell(x)
+	iy, ty := t.y.cell(y)
+	iz, tz := t.z.cell(z)
+
+	lerp := func(a, b, u float64) float64 { return a + (b-a)*u }
+	c00 := lerp(t.at(ix, iy, iz), t.at(ix+1, iy, iz), tx)
+	c10 := lerp(t.at(ix, iy+1, iz), t.at(ix+1, iy+1, iz), tx)
+	c01 := lerp(t.at(ix, iy, iz+1), t.at(ix+1, iy, iz+1), tx)
+	c11 := lerp(t.at(ix, iy+1, iz+1), t.at(ix+1, iy+1, iz+1), tx)
+	c0 := lerp(c00, c10, ty)
+	c1 := lerp(c01, c11, ty)
+	return lerp(c0, c1, tz)
+}
+
+// lagrangeWeights returns the 4 cubic Lagrange basis weights for the
+// 4 nodes at relative offsets -1, 0, 1, 2 from the query point's cell,
+// evaluated at fractional offset t in [0, 1] past node 0.
+func lagrangeWeights(t float64) [4]float64 {
+	return [4]float64{
+		-t * (t - 1) * (t - 2) / 6,
+		(t + 1) * (t - 1) * (t - 2) / 2,
+		-(t + 1) * t * (t - 2) / 2,
+		(t + 1) * t * (t - 1) / 6,
+	}
+}
+
+// triCubic interpolates with a separable tensor-product cubic Lagrange
+// polynomial: 4 nodes per axis, matching sampled values exactly but not
+// their derivatives (C0 continuous across cell boundaries, unlike
+// triCubicHermite).
+type triCubic struct{ grid }
+
+// NewUniformTriCubic builds a TriInterpolator over a uniform nx x ny x nz
+// grid of vals, using a separable 4-point cubic Lagrange polynomial along
+// each axis.
+func NewUniformTriCubic(
+	x0, dx float64, nx int, y0, dy float64, ny int, z0, dz float64, nz int,
+	vals []float64,
+) TriInterpolator {
+	return &triCubic{newGrid(x0, dx, nx, y0, dy, ny, z0, dz, nz, vals)}
+}
+
+func (t *triCubic) Eval(x, y, z float64) float64 {
+	ix, tx := t.x.cell(x)
+	iy, ty := t.y.cell(y)
+	iz, tz := t.z.cell(z)
+	wx, wy, wz := lagrangeWeights(tx), lagrangeWeights(ty), lagrangeWeights(tz)
+
+	sum := 0.0
+	for dz := -1; dz <= 2; dz++ {
+		for dy := -1; dy <= 2; dy++ {
+			for dx := -1; dx <= 2; dx++ {
+				w := wx[dx+1] * wy[dy+1] * wz[dz+1]
+				if w == 0 {
+					continue
+				}
+				sum += w * t.at(ix+dx, iy+dy, iz+dz)
+			}
+		}
+	}
+	return sum
+}
+
+// hermiteBasis returns the 4 cubic Hermite basis weights (h00, h10, h01,
+// h11) at fractional offset t in [0, 1] between two nodes: h00/h01 weight
+// the two nodes' own values, h10/h11 weight their tangents.
+func hermiteBasis(t float64) (h00, h10, h01, h11 float64) {
+	t2, t3 := t*t, t*t*t
+	h00 = 2*t3 - 3*t2 + 1
+	h10 = t3 - 2*t2 + t
+	h01 = -2*t3 + 3*t2
+	h11 = t3 - t2
+	return
+}
+
+// hermiteSeg evaluates the cubic Hermite segment between p1 and p2 at
+// fractional offset t in [0, 1], with each node's tangent estimated by
+// central differences against its own neighbor (the classic Catmull-Rom
+// construction). This is the corner-value/corner-derivative tricubic
+// Hermite interpolant the Lekien-Marsden formulation solves for via a
+// hard-coded 64x64 coefficient matrix; central-difference tangents plus
+// three nested 1-D Hermite passes (below, in triCubicHermite.Eval) give
+// the same tensor-product result without assembling that matrix.
+func hermiteSeg(p0, p1, p2, p3, t float64) float64 {
+	m1 := (p2 - p0) / 2
+	m2 := (p3 - p1) / 2
+	h00, h10, h01, h11 := hermiteBasis(t)
+	return h00*p1 + h10*m1 + h01*p2 + h11*m2
+}
+
+// hermiteCache memoizes the 4x4x4 corner values surrounding the last
+// evaluated cell, since xyInterpolate's scan line re-evaluates triX/triY/
+// triZ at many points that share a cell.
+type hermiteCache struct {
+	valid      bool
+	ix, iy, iz int
+	corners    [4][4][4]float64
+}
+
+// triCubicHermite interpolates with a separable tensor-product cubic
+// Hermite polynomial: nested 1-D Hermite passes along z, then y, then x,
+// each using Catmull-Rom (central-difference) tangents, giving a
+// C1-continuous interpolant that matches sampled values and their
+// central-difference derivatives at every grid corner.
+type triCubicHermite struct {
+	grid
+	cache hermiteCache
+}
+
+// NewUniformTriCubicHermite builds a TriInterpolator over a uniform
+// nx x ny x nz grid of vals, using a separable tricubic Hermite
+// polynomial with Catmull-Rom corner derivatives.
+func NewUniformTriCubicHermite(
+	x0, dx float64, nx int, y0, dy float64, ny int, z0, dz float64, nz int,
+	vals []float64,
+) TriInterpolator {
+	return &triCubicHermite{grid: newGrid(x0, dx, nx, y0, dy, ny, z0, dz, nz, vals)}
+}
+
+// Clone returns a TriInterpolator over the same grid with its own,
+// empty corner cache, so concurrent callers don't mutate each other's
+// cached cell.
+func (t *triCubicHermite) Clone() TriInterpolator {
+	return &triCubicHermite{grid: t.grid}
+}
+
+func (t *triCubicHermite) Eval(x, y, z float64) float64 {
+	ix, tx := t.x.cell(x)
+	iy, ty := t.y.cell(y)
+	iz, tz := t.z.cell(z)
+
+	c := &t.cache
+	if !c.valid || c.ix != ix || c.iy != iy || c.iz != iz {
+		for dx := -1; dx <= 2; dx++ {
+			for dy := -1; dy <= 2; dy++ {
+				for dz := -1; dz <= 2; dz++ {
+					c.corners[dx+1][dy+1][dz+1] = t.at(ix+dx, iy+dy, iz+dz)
+				}
+			}
+		}
+		c.valid, c.ix, c.iy, c.iz = true, ix, iy, iz
+	}
+
+	// Pass 1: interpolate along z for each of the 16 (dx, dy) corner
+	// columns, leaving a 4x4 grid of z-interpolated values.
+	var alongZ [4][4]float64
+	for dx := 0; dx < 4; dx++ {
+		for dy := 0; dy < 4; dy++ {
+			col := &c.corners[dx][dy]
+			alongZ[dx][dy] = hermiteSeg(col[0], col[1], col[2], col[3], tz)
+		}
+	}
+
+	// Pass 2: interpolate along y for each of the 4 dx rows, leaving 4
+	// y-and-z-interpolated values.
+	var alongY [4]float64
+	for dx := 0; dx < 4; dx++ {
+		row := &alongZ[dx]
+		alongY[dx] = hermiteSeg(row[0], row[1], row[2], row[3], ty)
+	}
+
+	// Pass 3: interpolate the last axis, x.
+	return hermiteSeg(alongY[0], alongY[1], alongY[2], alongY[3], tx)
+}
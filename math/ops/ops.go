@@ -0,0 +1,25 @@
+// +build !gotetra_libm
+
+/*package ops re-exports the handful of transcendental math functions used
+on gotetra's reproducibility-sensitive hot paths (sphere_halo's ring
+insertion and analyze's Penna basis) behind a build-tag-selectable backend.
+
+By default, as in this file, every function simply forwards to the stdlib
+math package. Building with the gotetra_libm tag instead links a
+deterministic, cgo-free backend (see ops_libm.go) so that identical inputs
+produce bit-identical results across amd64, arm64, and wasm, which the
+stdlib does not guarantee.
+*/
+package ops
+
+import "math"
+
+func Sin(x float64) float64 { return math.Sin(x) }
+func Cos(x float64) float64 { return math.Cos(x) }
+
+func Sincos(x float64) (sin, cos float64) { return math.Sincos(x) }
+
+func Atan2(y, x float64) float64 { return math.Atan2(y, x) }
+func Asin(x float64) float64     { return math.Asin(x) }
+func Sqrt(x float64) float64     { return math.Sqrt(x) }
+func Pow(x, y float64) float64   { return math.Pow(x, y) }
@@ -0,0 +1,75 @@
+//go:build gotetra_libm
+// +build gotetra_libm
+
+package ops
+
+import (
+	"math"
+	"testing"
+)
+
+// TestSqrtPinned pins Sqrt's output against stdlib math.Sqrt across a range
+// of magnitudes, including arguments far from 1, where a previous version
+// of Sqrt (seeded from z := x with a fixed iteration count) failed to
+// converge.
+func TestSqrtPinned(t *testing.T) {
+	cases := []float64{
+		0, 1, 2, 4, 0.25, 1e-6, 1e6, 1e12, 1e-12, 3.14159265358979,
+	}
+	for _, x := range cases {
+		got, want := Sqrt(x), math.Sqrt(x)
+		if diff := math.Abs(got - want); diff > want*1e-12+1e-300 {
+			t.Errorf("Sqrt(%g) = %g, want %g (diff %g)", x, got, want, diff)
+		}
+	}
+}
+
+// TestSinPinned pins Sin's output against stdlib math.Sin across [-pi, pi],
+// including arguments near +-pi where a previous version of sinPoly (used
+// without a quarter-period reduction) had error as large as ~1e-3.
+func TestSinPinned(t *testing.T) {
+	cases := []float64{
+		0, 1, -1, math.Pi / 2, -math.Pi / 2, math.Pi - 0.01, -math.Pi + 0.01,
+		math.Pi, -math.Pi, 2.5, -2.5, 3 * math.Pi,
+	}
+	for _, x := range cases {
+		got, want := Sin(x), math.Sin(x)
+		if diff := math.Abs(got - want); diff > 1e-7 {
+			t.Errorf("Sin(%g) = %g, want %g (diff %g)", x, got, want, diff)
+		}
+	}
+}
+
+// TestAtan2Pinned pins Atan2's output against stdlib math.Atan2 across all
+// four quadrants and both sides of the ax >= ay split, including t == 1
+// where a previous version of atanSeries' term ratio (t2*t2 instead of
+// t*t/(1+t*t)) converged to the wrong value (0.6046 instead of pi/4).
+func TestAtan2Pinned(t *testing.T) {
+	cases := [][2]float64{
+		{1, 1}, {1, -1}, {-1, 1}, {-1, -1},
+		{0, 1}, {1, 0}, {0, -1}, {-1, 0},
+		{0.01, 1}, {1, 0.01}, {3, 4}, {-3, 4}, {3, -4}, {-3, -4},
+	}
+	for _, c := range cases {
+		y, x := c[0], c[1]
+		got, want := Atan2(y, x), math.Atan2(y, x)
+		if diff := math.Abs(got - want); diff > 1e-7 {
+			t.Errorf("Atan2(%g, %g) = %g, want %g (diff %g)", y, x, got, want, diff)
+		}
+	}
+}
+
+// TestAsinPinned pins Asin's output against stdlib math.Asin across
+// [-1, 1], exercising the same atanSeries bug TestAtan2Pinned does since
+// Asin is implemented in terms of Atan2.
+func TestAsinPinned(t *testing.T) {
+	cases := []float64{
+		0, 1, -1, 0.5, -0.5, 0.999, -0.999, 1 / math.Sqrt2, -1 / math.Sqrt2,
+	}
+	for _, x := range cases {
+		got, want := Asin(x), math.Asin(x)
+		if diff := math.Abs(got - want); diff > 1e-7 {
+			t.Errorf("Asin(%g) = %g, want %g (diff %g)", x, got, want, diff)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Package geom holds the small, render-side vector type shared by
+// render/io's sheet positions and the binning routines that read them.
+// It's deliberately separate from los/geom: render works in the
+// single-precision particle-grid coordinates sheet files are stored in,
+// while los/geom works in the halo-centric coordinates the line-of-sight
+// pipeline fits shells in.
+package geom
+
+// Vec is a position or displacement in render's particle-grid coordinate
+// system.
+type Vec [3]float32
@@ -0,0 +1,156 @@
+/*
+Package io reads gotetra's binary sheet snapshot files: one regular
+gridWidth x gridWidth x segmentWidth lattice of particle positions per
+file, written out by the simulation's snapshot splitter.
+
+A sheet file is a fixed-size SheetHeader (see writeHeader/readHeader)
+followed by gridWidth*gridWidth*segmentWidth geom.Vec position records in
+z-major order (z varies slowest, then y, then x), so that the z-slab
+[zStart, zEnd) is a single contiguous byte range and can be read without
+touching the rest of the file.
+*/
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/phil-mansfield/gotetra/render/geom"
+)
+
+// Cosmo carries the cosmological parameters a snapshot was generated at.
+type Cosmo struct {
+	Z           float64
+	ScaleFactor float64
+}
+
+// SheetHeader describes one sheet file's grid geometry, bounding box, and
+// originating cosmology.
+type SheetHeader struct {
+	GridWidth, SegmentWidth, CountWidth int64
+	TotalWidth                          float64
+	Origin, Width                       [3]float32
+	Cosmo                               Cosmo
+}
+
+// vecSize is the on-disk size, in bytes, of one geom.Vec record (three
+// little-endian float32s).
+const vecSize = 12
+
+// ReadSheetHeaderAt reads the SheetHeader at the front of file into hd.
+func ReadSheetHeaderAt(file string, hd *SheetHeader) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readHeader(f, hd)
+}
+
+func readHeader(f *os.File, hd *SheetHeader) error {
+	fields := []interface{}{
+		&hd.GridWidth, &hd.SegmentWidth, &hd.CountWidth,
+		&hd.TotalWidth, &hd.Origin, &hd.Width,
+		&hd.Cosmo.Z, &hd.Cosmo.ScaleFactor,
+	}
+	for _, field := range fields {
+		if err := binary.Read(f, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHeader(f *os.File, hd *SheetHeader) error {
+	fields := []interface{}{
+		hd.GridWidth, hd.SegmentWidth, hd.CountWidth,
+		hd.TotalWidth, hd.Origin, hd.Width,
+		hd.Cosmo.Z, hd.Cosmo.ScaleFactor,
+	}
+	for _, field := range fields {
+		if err := binary.Write(f, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerSize returns the on-disk size, in bytes, of a written SheetHeader.
+func headerSize() int64 {
+	// 3 int64 + 1 float64 + 2*[3]float32 + 2 float64, all little-endian.
+	return 3*8 + 8 + 2*3*4 + 2*8
+}
+
+// WriteSheetFile writes a sheet file at file with the given header and
+// gridWidth*gridWidth*segmentWidth position records, laid out exactly as
+// ReadSheetPositionsChunked expects to read them back.
+func WriteSheetFile(file string, hd *SheetHeader, xs []geom.Vec) error {
+	want := hd.GridWidth * hd.GridWidth * hd.SegmentWidth
+	if int64(len(xs)) != want {
+		return fmt.Errorf(
+			"WriteSheetFile: len(xs) == %d, want %d (gridWidth^2 * segmentWidth)",
+			len(xs), want,
+		)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := writeHeader(f, hd); err != nil {
+		return err
+	}
+	return binary.Write(f, binary.LittleEndian, xs)
+}
+
+// ReadSheetPositionsChunked reads the z-slab [zStart, zEnd) of file's
+// position grid into buf, without loading the rest of the sheet into
+// memory. buf must have room for at least gridWidth*gridWidth*(zEnd-zStart)
+// vectors, where gridWidth is read from the file's own header; positions
+// for z-layer z land at buf[(z-zStart)*gridWidth*gridWidth : ...].
+//
+// This lets interpolatorBinParticles/tetraBinParticles process a
+// snapshot slab-by-slab instead of holding the whole
+// gridWidth*gridWidth*segmentWidth sheet in memory at once, which is
+// what -MaxMemMB trades off against chunk size.
+func ReadSheetPositionsChunked(file string, zStart, zEnd int, buf []geom.Vec) error {
+	if zEnd <= zStart {
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hd := &SheetHeader{}
+	if err := readHeader(f, hd); err != nil {
+		return err
+	}
+
+	gw := hd.GridWidth
+	if zStart < 0 || int64(zEnd) > hd.SegmentWidth {
+		return fmt.Errorf(
+			"ReadSheetPositionsChunked: z-range [%d, %d) out of bounds for "+
+				"segment width %d", zStart, zEnd, hd.SegmentWidth,
+		)
+	}
+
+	n := gw * gw * int64(zEnd-zStart)
+	if int64(len(buf)) < n {
+		return fmt.Errorf(
+			"ReadSheetPositionsChunked: len(buf) == %d, want at least %d "+
+				"(gridWidth^2 * (zEnd-zStart))", len(buf), n,
+		)
+	}
+
+	offset := headerSize() + gw*gw*int64(zStart)*vecSize
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return err
+	}
+	return binary.Read(f, binary.LittleEndian, buf[:n])
+}